@@ -194,6 +194,18 @@ LOCAL_REQUIRED_MODULES += \
     merged_service_contexts_test \
     merged_hwservice_contexts_test
 
+# Checks keystore2 namespaces of all partitions at once, as they must be unique
+LOCAL_REQUIRED_MODULES += \
+    keystore2_key_contexts_test
+
+# Checks that labels aren't shared between APEXes
+LOCAL_REQUIRED_MODULES += \
+    apex_file_contexts_test
+
+# Checks seapp_contexts entries which override each other across partitions
+LOCAL_REQUIRED_MODULES += \
+    seapp_contexts_test
+
 include $(BUILD_PHONY_PACKAGE)
 
 # selinux_policy is a main goal and triggers lots of tests.
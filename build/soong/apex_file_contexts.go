@@ -0,0 +1,162 @@
+// Copyright 2024 The Android Open Source Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selinux
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+)
+
+func init() {
+	ctx := android.InitRegistrationContext
+	ctx.RegisterModuleType("apex_file_contexts", apexFileContextsFactory)
+	ctx.RegisterParallelSingletonModuleType("apex_file_contexts_test", apexFileContextsTestFactory)
+}
+
+type apexFileContextsProperties struct {
+	// Name of the APEX. Defaults to the module name without the "-file_contexts" suffix.
+	Apex_name *string
+
+	// Other APEXes which may use the same labels as this APEX, e.g. a debug flavor of the same
+	// APEX. Labels are otherwise owned by a single APEX.
+	Shares_labels_with []string
+}
+
+type apexFileContextsInfo struct {
+	// Name of the APEX
+	ApexName string
+	// Checked file_contexts of the APEX
+	FileContexts android.Path
+	// Other APEXes which may use the same labels
+	SharesLabelsWith []string
+}
+
+var apexFileContextsProviderKey = blueprint.NewProvider[apexFileContextsInfo]()
+
+// apex_file_contexts builds file_contexts of an APEX. Specs are relative to the APEX root, and
+// labels must be file types declared in the given sepolicy. The output can be used as the
+// file_contexts property of an apex module, just like a filegroup.
+func apexFileContextsFactory() android.Module {
+	m := newModule()
+	m.AddProperties(&m.apexProperties)
+	m.build = m.buildApexFileContexts
+	return m
+}
+
+func (m *selinuxContextsModule) apexName() string {
+	return proptools.StringDefault(m.apexProperties.Apex_name, strings.TrimSuffix(m.Name(), "-file_contexts"))
+}
+
+func (m *selinuxContextsModule) buildApexFileContexts(ctx android.ModuleContext, inputs android.Paths) android.Path {
+	// APEX file_contexts are bundled into the APEX, not installed to any partition.
+	m.SkipInstall()
+
+	if m.properties.Remove_comment == nil {
+		m.properties.Remove_comment = proptools.BoolPtr(true)
+	}
+	builtCtx := m.buildGeneralContexts(ctx, inputs)
+
+	if proptools.String(m.seappProperties.Sepolicy) == "" {
+		ctx.PropertyErrorf("sepolicy", "can't be empty")
+		return builtCtx
+	}
+
+	out := pathForModuleOut(ctx, "checked", m.stem())
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().BuiltTool("apex_file_contexts_check").
+		Text("check").
+		FlagWithInput("-p ", android.PathForModuleSrc(ctx, proptools.String(m.seappProperties.Sepolicy))).
+		FlagWithInput("-f ", builtCtx)
+	rule.Command().Text("cp -f").Input(builtCtx).Output(out)
+	rule.Build("apex_file_contexts_check", "checking APEX file_contexts: "+m.Name())
+
+	android.SetProvider(ctx, apexFileContextsProviderKey, apexFileContextsInfo{
+		ApexName:         m.apexName(),
+		FileContexts:     out,
+		SharesLabelsWith: m.apexProperties.Shares_labels_with,
+	})
+	return out
+}
+
+// apex_file_contexts_test checks that no label is used by more than one APEX, across all
+// apex_file_contexts modules in the tree.
+func apexFileContextsTestFactory() android.SingletonModule {
+	m := &apexFileContextsTestModule{}
+	android.InitAndroidModule(m)
+	return m
+}
+
+type apexFileContextsTestModule struct {
+	android.SingletonModuleBase
+	testTimestamp android.ModuleOutPath
+}
+
+func (m *apexFileContextsTestModule) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	// The rule is built in GenerateSingletonBuildActions, after every apex_file_contexts module
+	// has generated its output.
+	m.testTimestamp = android.PathForModuleOut(ctx, "timestamp")
+}
+
+func (m *apexFileContextsTestModule) GenerateSingletonBuildActions(ctx android.SingletonContext) {
+	outputs := make(map[string]android.Path)
+	var allowedPairs []string
+	ctx.VisitAllModules(func(module android.Module) {
+		info, ok := android.SingletonModuleProvider(ctx, module, apexFileContextsProviderKey)
+		if !ok {
+			return
+		}
+		apex := info.ApexName
+		if _, exists := outputs[apex]; exists {
+			ctx.Errorf("%q: APEX %q has more than one apex_file_contexts module", ctx.ModuleName(module), apex)
+			return
+		}
+		outputs[apex] = info.FileContexts
+		for _, other := range info.SharesLabelsWith {
+			allowedPairs = append(allowedPairs, apex+":"+other)
+		}
+	})
+	sort.Strings(allowedPairs)
+
+	rule := android.NewRuleBuilder(pctx, ctx)
+	if len(outputs) > 0 {
+		cmd := rule.Command().BuiltTool("apex_file_contexts_check").Text("collisions")
+		for _, apex := range android.SortedKeys(outputs) {
+			cmd.FlagWithInput("-f "+apex+"=", outputs[apex])
+		}
+		cmd.FlagForEachArg("--allow-sharing ", allowedPairs)
+	}
+	rule.Command().Text("touch").Output(m.testTimestamp)
+	rule.Build("apex_file_contexts_test", "checking label collisions between APEXes")
+}
+
+func (m *apexFileContextsTestModule) AndroidMkEntries() []android.AndroidMkEntries {
+	return []android.AndroidMkEntries{android.AndroidMkEntries{
+		Class: "FAKE",
+		// OutputFile is needed, even though BUILD_PHONY_PACKAGE doesn't use it.
+		// Without OutputFile this module won't be exported to Makefile.
+		OutputFile: android.OptionalPathForPath(m.testTimestamp),
+		Include:    "$(BUILD_PHONY_PACKAGE)",
+		ExtraEntries: []android.AndroidMkExtraEntriesFunc{
+			func(ctx android.AndroidMkExtraEntriesContext, entries *android.AndroidMkEntries) {
+				entries.SetString("LOCAL_ADDITIONAL_DEPENDENCIES", m.testTimestamp.String())
+			},
+		},
+	}}
+}
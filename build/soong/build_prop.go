@@ -0,0 +1,125 @@
+// Copyright 2024 The Android Open Source Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selinux
+
+import (
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("build_prop_contexts_test", buildPropContextsTestFactory)
+}
+
+type buildPropContextsTestProperties struct {
+	// property_contexts of all partitions, e.g.
+	// [":plat_property_contexts", ":vendor_property_contexts"].
+	Property_contexts []string `android:"path"`
+
+	// Precompiled sepolicy binary of the device, e.g. ":precompiled_sepolicy".
+	Sepolicy *string `android:"path"`
+
+	// build.prop and default.prop files, by the partition they're installed to.
+	Prop_files struct {
+		System      []string `android:"path"`
+		System_ext  []string `android:"path"`
+		Product     []string `android:"path"`
+		Vendor      []string `android:"path"`
+		Odm         []string `android:"path"`
+		Vendor_dlkm []string `android:"path"`
+		Odm_dlkm    []string `android:"path"`
+	}
+}
+
+type buildPropContextsTestModule struct {
+	android.ModuleBase
+
+	properties    buildPropContextsTestProperties
+	testTimestamp android.ModuleOutPath
+}
+
+// build_prop_contexts_test checks every property set by prop files of each partition against
+// property_contexts. Each property must have a context other than the default one, must be owned
+// by the partition setting it, and its value must match the declared type. Prop files installed
+// by Soong modules can be referenced with se_installed_files, e.g.
+// ":se_installed_files{.vendor_props}".
+func buildPropContextsTestFactory() android.Module {
+	m := &buildPropContextsTestModule{}
+	m.AddProperties(&m.properties)
+	android.InitAndroidArchModule(m, android.DeviceSupported, android.MultilibCommon)
+	return m
+}
+
+func (m *buildPropContextsTestModule) propFiles() map[string][]string {
+	p := &m.properties.Prop_files
+	return map[string][]string{
+		"system":      p.System,
+		"system_ext":  p.System_ext,
+		"product":     p.Product,
+		"vendor":      p.Vendor,
+		"odm":         p.Odm,
+		"vendor_dlkm": p.Vendor_dlkm,
+		"odm_dlkm":    p.Odm_dlkm,
+	}
+}
+
+func (m *buildPropContextsTestModule) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	if len(m.properties.Property_contexts) == 0 {
+		ctx.PropertyErrorf("property_contexts", "can't be empty")
+		return
+	}
+	if proptools.String(m.properties.Sepolicy) == "" {
+		ctx.PropertyErrorf("sepolicy", "can't be empty")
+		return
+	}
+
+	m.testTimestamp = android.PathForModuleOut(ctx, "timestamp")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	cmd := rule.Command().BuiltTool("build_prop_check").
+		FlagWithInput("-p ", android.PathForModuleSrc(ctx, proptools.String(m.properties.Sepolicy))).
+		FlagForEachInput("-c ", android.PathsForModuleSrc(ctx, m.properties.Property_contexts))
+
+	propFiles := m.propFiles()
+	hasPropFiles := false
+	for _, partition := range android.SortedKeys(propFiles) {
+		for _, path := range android.PathsForModuleSrc(ctx, propFiles[partition]) {
+			cmd.FlagWithInput("--prop "+partition+"=", path)
+			hasPropFiles = true
+		}
+	}
+	if !hasPropFiles {
+		ctx.PropertyErrorf("prop_files", "can't be empty")
+		return
+	}
+
+	rule.Command().Text("touch").Output(m.testTimestamp)
+	rule.Build("build_prop_contexts_test", "checking build.prop properties: "+ctx.ModuleName())
+}
+
+func (m *buildPropContextsTestModule) AndroidMkEntries() []android.AndroidMkEntries {
+	return []android.AndroidMkEntries{android.AndroidMkEntries{
+		Class: "FAKE",
+		// OutputFile is needed, even though BUILD_PHONY_PACKAGE doesn't use it.
+		// Without OutputFile this module won't be exported to Makefile.
+		OutputFile: android.OptionalPathForPath(m.testTimestamp),
+		Include:    "$(BUILD_PHONY_PACKAGE)",
+		ExtraEntries: []android.AndroidMkExtraEntriesFunc{
+			func(ctx android.AndroidMkExtraEntriesContext, entries *android.AndroidMkEntries) {
+				entries.SetString("LOCAL_ADDITIONAL_DEPENDENCIES", m.testTimestamp.String())
+			},
+		},
+	}}
+}
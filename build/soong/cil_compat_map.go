@@ -64,6 +64,13 @@ type cilCompatMapProperties struct {
 	// other modules that produce source files like genrule or filegroup using
 	// the syntax ":module". srcs has to be non-empty.
 	Bottom_half []string `android:"path"`
+	// Ordered list of mapping steps, newest first, e.g.
+	// [":34.0.board.compat.map{.plat_private}", ":33.0.board.compat.map{.plat_private}"]. Each step
+	// maps the types of the version the step before it maps to. The steps are combined into one
+	// mapping file, after checking that each step follows the one before it. Types which vanish or
+	// fan out along the chain are reported in the ".report" output. Can't be set along with
+	// top_half or bottom_half.
+	Chain []string `android:"path"`
 	// name of the output
 	Stem *string
 	// Target version that this module supports. This module will be ignored if platform sepolicy
@@ -77,6 +84,8 @@ type cilCompatMap struct {
 	// (.intermediate) module output path as installation source.
 	installSource android.OptionalPath
 	installPath   android.InstallPath
+	// report of the combined chain, if chain is set.
+	chainReport android.OptionalPath
 }
 
 type CilCompatMapGenerator interface {
@@ -110,6 +119,11 @@ func (c *cilCompatMap) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 
 	c.installPath = android.PathForModuleInstall(ctx, "etc", "selinux", "mapping")
 
+	if len(c.properties.Chain) > 0 {
+		c.buildChain(ctx)
+		return
+	}
+
 	srcFiles := expandSeSources(ctx, c.properties.Bottom_half)
 
 	for _, src := range srcFiles {
@@ -146,6 +160,32 @@ func (c *cilCompatMap) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	}
 }
 
+func (c *cilCompatMap) buildChain(ctx android.ModuleContext) {
+	if c.properties.Top_half != nil || len(c.properties.Bottom_half) > 0 {
+		ctx.PropertyErrorf("chain", "can't be set along with top_half or bottom_half")
+		return
+	}
+
+	steps := android.PathsForModuleSrc(ctx, c.properties.Chain)
+	for _, step := range steps {
+		if step.Ext() != ".cil" {
+			ctx.PropertyErrorf("chain", "%s has to be a .cil file.", step.String())
+		}
+	}
+
+	out := android.PathForModuleGen(ctx, c.Name())
+	report := android.PathForModuleGen(ctx, c.Name()+".report.txt")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().BuiltTool("combine_maps").
+		FlagForEachInput("-c ", steps).
+		FlagWithOutput("-r ", report).
+		FlagWithOutput("-o ", out)
+	rule.Build("combine_maps_chain", "Combining chain of mapping files for "+c.Name())
+
+	c.installSource = android.OptionalPathForPath(out)
+	c.chainReport = android.OptionalPathForPath(report)
+}
+
 func (c *cilCompatMap) DepsMutator(ctx android.BottomUpMutatorContext) {
 	if c.properties.Top_half != nil {
 		ctx.AddDependency(c, TopHalfDepTag, String(c.properties.Top_half))
@@ -185,5 +225,11 @@ func (c *cilCompatMap) OutputFiles(tag string) (android.Paths, error) {
 			return nil, nil
 		}
 	}
+	if tag == ".report" {
+		if c.chainReport.Valid() {
+			return android.Paths{c.chainReport.Path()}, nil
+		}
+		return nil, fmt.Errorf("%q is only available when chain is set", tag)
+	}
 	return nil, fmt.Errorf("Unknown tag %q", tag)
 }
@@ -16,6 +16,8 @@ package selinux
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/google/blueprint/proptools"
 
@@ -29,6 +31,7 @@ var (
 func init() {
 	ctx := android.InitRegistrationContext
 	ctx.RegisterModuleType("se_compat_cil", compatCilFactory)
+	ctx.RegisterModuleType("se_compat_mapping_check", compatMappingCheckFactory)
 	ctx.RegisterParallelSingletonModuleType("se_compat_test", compatTestFactory)
 }
 
@@ -125,6 +128,81 @@ func (c *compatCil) OutputFiles(tag string) (android.Paths, error) {
 
 var _ android.OutputFileProducer = (*compatCil)(nil)
 
+type compatMappingCheckProperties struct {
+	// Compat version to check.
+	Version *string
+
+	// Versioned public policy of the version, e.g. ":plat_pub_versioned.cil".
+	Versioned_policy *string `android:"path"`
+
+	// Mapping files of the version, for each partition.
+	Plat_mapping       *string `android:"path"`
+	System_ext_mapping *string `android:"path"`
+	Product_mapping    *string `android:"path"`
+
+	// Current policy, which the mapping files map to.
+	Srcs []string `android:"path"`
+}
+
+type compatMappingCheck struct {
+	android.ModuleBase
+	properties compatMappingCheckProperties
+	output     android.Path
+}
+
+// se_compat_mapping_check checks that every versioned type of the versioned public policy is mapped,
+// and that the mapping files only map to declared types. se_compat_test runs it before compiling
+// each compat version, so that a public type removed without a mapping is reported with the version
+// and partition instead of as a secilc error.
+func compatMappingCheckFactory() android.Module {
+	c := &compatMappingCheck{}
+	c.AddProperties(&c.properties)
+	android.InitAndroidArchModule(c, android.DeviceSupported, android.MultilibCommon)
+	return c
+}
+
+func (c *compatMappingCheck) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	ver := proptools.String(c.properties.Version)
+	if ver == "" || c.properties.Versioned_policy == nil {
+		ctx.ModuleErrorf("version and versioned_policy must be set")
+		return
+	}
+
+	out := android.PathForModuleOut(ctx, ctx.ModuleName()+".txt")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	cmd := rule.Command().BuiltTool("compat_mapping_check").
+		FlagWithArg("-v ", ver).
+		FlagWithInput("-p ", android.PathForModuleSrc(ctx, *c.properties.Versioned_policy))
+	for _, mapping := range []struct {
+		partition string
+		path      *string
+	}{
+		{"plat", c.properties.Plat_mapping},
+		{"system_ext", c.properties.System_ext_mapping},
+		{"product", c.properties.Product_mapping},
+	} {
+		if mapping.path != nil {
+			cmd.FlagWithInput("-m "+mapping.partition+":", android.PathForModuleSrc(ctx, *mapping.path))
+		}
+	}
+	cmd.FlagForEachInput("-c ", android.PathsForModuleSrc(ctx, c.properties.Srcs)).
+		FlagWithOutput("-o ", out)
+	rule.Build("compat_mapping_check", "Checking compat mapping files of "+ver)
+
+	c.output = out
+}
+
+func (c *compatMappingCheck) OutputFiles(tag string) (android.Paths, error) {
+	switch tag {
+	case "":
+		return android.Paths{c.output}, nil
+	default:
+		return nil, fmt.Errorf("unsupported module reference tag %q", tag)
+	}
+}
+
+var _ android.OutputFileProducer = (*compatMappingCheck)(nil)
+
 // se_compat_test checks if compat files ({ver}.cil, {ver}.compat.cil) files are compatible with
 // current policy.
 func compatTestFactory() android.SingletonModule {
@@ -142,8 +220,16 @@ type compatTestModule struct {
 	properties struct {
 		// Default modules for conf
 		Defaults []string
+
+		// Test only versions at or above this version, e.g. "33.0". Tests of lower versions can
+		// still be run with their own phony targets. SEPOLICY_COMPAT_TEST_MIN_VERSION in the build
+		// environment overrides it.
+		Min_version *string
 	}
 
+	versionResults      map[string]android.Path
+	versionChecks       map[string]android.WritablePath
+	compatTestSummary   android.ModuleOutPath
 	compatTestTimestamp android.ModuleOutPath
 }
 
@@ -187,6 +273,28 @@ func (f *compatTestModule) createPlatPubVersionedModule(ctx android.LoadHookCont
 	})
 }
 
+func (f *compatTestModule) createCompatMappingCheckModule(ctx android.LoadHookContext, ver string) {
+	versionedPolicy := fmt.Sprintf(":plat_pub_versioned_%s.cil", ver)
+	if ver == ctx.DeviceConfig().BoardSepolicyVers() {
+		versionedPolicy = ":plat_pub_versioned.cil"
+	}
+
+	ctx.CreateModule(compatMappingCheckFactory, &nameProperties{
+		Name: proptools.StringPtr(fmt.Sprintf("%s_compat_mapping_check", ver)),
+	}, &compatMappingCheckProperties{
+		Version:            proptools.StringPtr(ver),
+		Versioned_policy:   proptools.StringPtr(versionedPolicy),
+		Plat_mapping:       proptools.StringPtr(fmt.Sprintf(":plat_%s.cil", ver)),
+		System_ext_mapping: proptools.StringPtr(fmt.Sprintf(":system_ext_%s.cil", ver)),
+		Product_mapping:    proptools.StringPtr(fmt.Sprintf(":product_%s.cil", ver)),
+		Srcs: []string{
+			":plat_sepolicy.cil",
+			":system_ext_sepolicy.cil",
+			":product_sepolicy.cil",
+		},
+	})
+}
+
 func (f *compatTestModule) createCompatTestModule(ctx android.LoadHookContext, ver string) {
 	srcs := []string{
 		":plat_sepolicy.cil",
@@ -216,12 +324,15 @@ func (f *compatTestModule) createCompatTestModule(ctx android.LoadHookContext, v
 		Srcs:              srcs,
 		Ignore_neverallow: proptools.BoolPtr(true),
 		Installable:       proptools.BoolPtr(false),
+		Prechecks:         []string{fmt.Sprintf(":%s_compat_mapping_check", ver)},
+		Record_result:     proptools.BoolPtr(true),
 	})
 }
 
 func (f *compatTestModule) loadHook(ctx android.LoadHookContext) {
 	for _, ver := range ctx.DeviceConfig().PlatformSepolicyCompatVersions() {
 		f.createPlatPubVersionedModule(ctx, ver)
+		f.createCompatMappingCheckModule(ctx, ver)
 		f.createCompatTestModule(ctx, ver)
 	}
 }
@@ -236,8 +347,35 @@ func (f *compatTestModule) GenerateSingletonBuildActions(ctx android.SingletonCo
 	// does nothing; se_compat_test is a singeton because two compat test modules don't make sense.
 }
 
+// minVersion returns the lowest tested version, or "" if all versions are tested.
+func (f *compatTestModule) minVersion(ctx android.BaseModuleContext) string {
+	if v := ctx.Config().Getenv("SEPOLICY_COMPAT_TEST_MIN_VERSION"); v != "" {
+		return v
+	}
+	return proptools.String(f.properties.Min_version)
+}
+
+// testedVersion returns whether ver is at or above min_version.
+func (f *compatTestModule) testedVersion(ctx android.BaseModuleContext, ver string) bool {
+	minVersion := f.minVersion(ctx)
+	if minVersion == "" {
+		return true
+	}
+	v, err := strconv.ParseFloat(ver, 64)
+	if err != nil {
+		ctx.ModuleErrorf("invalid compat version %q: %s", ver, err)
+		return false
+	}
+	min, err := strconv.ParseFloat(minVersion, 64)
+	if err != nil {
+		ctx.PropertyErrorf("min_version", "invalid version %q: %s", minVersion, err)
+		return false
+	}
+	return v >= min
+}
+
 func (f *compatTestModule) GenerateAndroidBuildActions(ctx android.ModuleContext) {
-	var inputs android.Paths
+	f.versionResults = make(map[string]android.Path)
 	ctx.VisitDirectDepsWithTag(compatTestDepTag, func(child android.Module) {
 		o, ok := child.(android.OutputFileProducer)
 		if !ok {
@@ -252,26 +390,77 @@ func (f *compatTestModule) GenerateAndroidBuildActions(ctx android.ModuleContext
 			panic(fmt.Errorf("Module %q should produce exactly one output, but did %q", ctx.OtherModuleName(child), outputs.Strings()))
 		}
 
-		inputs = append(inputs, outputs[0])
+		ver := strings.TrimSuffix(ctx.OtherModuleName(child), "_compat_test")
+		f.versionResults[ver] = outputs[0]
 	})
 
+	// Each version has its own check, so that its phony target fails if the version fails.
+	f.versionChecks = make(map[string]android.WritablePath)
+	for _, ver := range android.SortedKeys(f.versionResults) {
+		result := f.versionResults[ver]
+		check := android.PathForModuleOut(ctx, ver, "timestamp")
+		rule := android.NewRuleBuilder(pctx, ctx)
+		rule.Command().Text("if [ \"$(head -n 1").Input(result).Text(")\" != passed ]; then").
+			Text("echo \"sepolicy compat test of " + ver + " failed:\" >&2;").
+			Text("tail -n +2").Input(result).Text(">&2; exit 1; fi")
+		rule.Command().Text("touch").Output(check)
+		rule.Build("compat_"+ver, "compat test of "+ver+" for: "+f.Name())
+		f.versionChecks[ver] = check
+	}
+
+	// The summary lists the result of every version, so that all failing versions are named at once.
+	f.compatTestSummary = android.PathForModuleOut(ctx, "compat_test_summary.txt")
 	f.compatTestTimestamp = android.PathForModuleOut(ctx, "timestamp")
 	rule := android.NewRuleBuilder(pctx, ctx)
-	rule.Command().Text("touch").Output(f.compatTestTimestamp).Implicits(inputs)
+	cmd := rule.Command().Text("(")
+	for _, ver := range ctx.DeviceConfig().PlatformSepolicyCompatVersions() {
+		if f.testedVersion(ctx, ver) {
+			cmd.Text("echo \"" + ver + ": $(head -n 1").Input(f.versionResults[ver]).Text(")\";")
+		} else {
+			cmd.Text("echo \"" + ver + ": skipped, below min_version " + f.minVersion(ctx) + "\";")
+		}
+	}
+	cmd.Text(") >").Output(f.compatTestSummary)
+	rule.Command().Text("if grep -q ': failed$'").Input(f.compatTestSummary).Text("; then").
+		Text("echo \"sepolicy compat tests failed:\" >&2;").
+		Text("grep ': failed$'").Input(f.compatTestSummary).Text(">&2;").
+		Text("echo \"Run e.g. m sepolicy_compat_test_<ver> to see the errors of a version.\" >&2;").
+		Text("exit 1; fi")
+	rule.Command().Text("touch").Output(f.compatTestTimestamp)
 	rule.Build("compat", "compat test timestamp for: "+f.Name())
 }
 
+func (f *compatTestModule) OutputFiles(tag string) (android.Paths, error) {
+	switch tag {
+	case "":
+		return android.Paths{f.compatTestSummary}, nil
+	default:
+		return nil, fmt.Errorf("unsupported module reference tag %q", tag)
+	}
+}
+
+// AndroidMkEntries defines a phony target for all tested versions, and one for each version, e.g.
+// sepolicy_compat_test_34.0, which tests the version even if it's below min_version.
 func (f *compatTestModule) AndroidMkEntries() []android.AndroidMkEntries {
-	return []android.AndroidMkEntries{android.AndroidMkEntries{
-		Class: "FAKE",
-		// OutputFile is needed, even though BUILD_PHONY_PACKAGE doesn't use it.
-		// Without OutputFile this module won't be exported to Makefile.
-		OutputFile: android.OptionalPathForPath(f.compatTestTimestamp),
-		Include:    "$(BUILD_PHONY_PACKAGE)",
-		ExtraEntries: []android.AndroidMkExtraEntriesFunc{
-			func(ctx android.AndroidMkExtraEntriesContext, entries *android.AndroidMkEntries) {
-				entries.SetString("LOCAL_ADDITIONAL_DEPENDENCIES", f.compatTestTimestamp.String())
+	phony := func(subName string, dep android.Path) android.AndroidMkEntries {
+		return android.AndroidMkEntries{
+			Class: "FAKE",
+			// OutputFile is needed, even though BUILD_PHONY_PACKAGE doesn't use it.
+			// Without OutputFile this module won't be exported to Makefile.
+			OutputFile: android.OptionalPathForPath(dep),
+			SubName:    subName,
+			Include:    "$(BUILD_PHONY_PACKAGE)",
+			ExtraEntries: []android.AndroidMkExtraEntriesFunc{
+				func(ctx android.AndroidMkExtraEntriesContext, entries *android.AndroidMkEntries) {
+					entries.SetString("LOCAL_ADDITIONAL_DEPENDENCIES", dep.String())
+				},
 			},
-		},
-	}}
+		}
+	}
+
+	entries := []android.AndroidMkEntries{phony("", f.compatTestTimestamp)}
+	for _, ver := range android.SortedKeys(f.versionChecks) {
+		entries = append(entries, phony("_"+ver, f.versionChecks[ver]))
+	}
+	return entries
 }
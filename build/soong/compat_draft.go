@@ -0,0 +1,171 @@
+// Copyright 2024 The Android Open Source Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selinux
+
+import (
+	"fmt"
+
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+)
+
+var compatDraftCurrentTag = dependencyTag{name: "compat_draft_current"}
+var compatDraftPrebuiltTag = dependencyTag{name: "compat_draft_prebuilt"}
+
+func init() {
+	android.RegisterModuleType("se_compat_draft", compatDraftFactory)
+}
+
+type compatDraftProperties struct {
+	// Version to draft compat files for. Defaults to the latest of PLATFORM_SEPOLICY_COMPAT_VERSIONS.
+	Version *string
+
+	// Version whose compat files in private/compat tell how to map new types. Defaults to the
+	// version before version in PLATFORM_SEPOLICY_COMPAT_VERSIONS.
+	Latest_version *string
+}
+
+type compatDraft struct {
+	android.ModuleBase
+	properties compatDraftProperties
+
+	mapping android.ModuleOutPath
+	compat  android.ModuleOutPath
+	ignore  android.ModuleOutPath
+	report  android.ModuleOutPath
+}
+
+// se_compat_draft diffs the current plat public policy against the prebuilt public policy of a
+// version, and drafts compat/<ver>/<ver>.cil, <ver>.compat.cil and <ver>.ignore.cil for new, removed
+// and renamed types. Its outputs are the three files, along with a report of the decisions to
+// review before checking them in.
+//
+// se_freeze_snapshot writes the same files when the version is frozen. They only map the types of
+// the frozen version to themselves, since the policy can't have changed yet. Once the version is in
+// PLATFORM_SEPOLICY_COMPAT_VERSIONS and the current policy moves on, the drafted files replace
+// them.
+func compatDraftFactory() android.Module {
+	m := &compatDraft{}
+	m.AddProperties(&m.properties)
+	android.InitAndroidArchModule(m, android.DeviceSupported, android.MultilibCommon)
+	return m
+}
+
+// versions returns the version to draft compat files for, and the version before it. Either may be
+// empty.
+func (m *compatDraft) versions(ctx android.EarlyModuleContext) (string, string) {
+	compatVersions := ctx.DeviceConfig().PlatformSepolicyCompatVersions()
+	ver := proptools.String(m.properties.Version)
+	if ver == "" && len(compatVersions) > 0 {
+		ver = compatVersions[len(compatVersions)-1]
+	}
+
+	latest := proptools.String(m.properties.Latest_version)
+	if latest == "" {
+		for i, v := range compatVersions {
+			if v == ver && i > 0 {
+				latest = compatVersions[i-1]
+			}
+		}
+	}
+	return ver, latest
+}
+
+func (m *compatDraft) DepsMutator(ctx android.BottomUpMutatorContext) {
+	ver, _ := m.versions(ctx)
+	if ver == "" {
+		return
+	}
+	ctx.AddDependency(ctx.Module(), compatDraftCurrentTag, "base_plat_pub_policy.cil")
+	ctx.AddDependency(ctx.Module(), compatDraftPrebuiltTag, ver+"_plat_pub_policy.cil")
+}
+
+func (m *compatDraft) outputFileOfDep(ctx android.ModuleContext, depTag dependencyTag) android.Path {
+	var result android.Path
+	ctx.VisitDirectDepsWithTag(depTag, func(dep android.Module) {
+		if producer, ok := dep.(android.OutputFileProducer); ok {
+			if outputs, err := producer.OutputFiles(""); err == nil && len(outputs) == 1 {
+				result = outputs[0]
+				return
+			}
+		}
+		ctx.ModuleErrorf("module %q must produce exactly one output file", ctx.OtherModuleName(dep))
+	})
+	return result
+}
+
+func (m *compatDraft) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	ver, latest := m.versions(ctx)
+	m.mapping = android.PathForModuleOut(ctx, ver+".cil")
+	m.compat = android.PathForModuleOut(ctx, ver+".compat.cil")
+	m.ignore = android.PathForModuleOut(ctx, ver+".ignore.cil")
+	m.report = android.PathForModuleOut(ctx, "compat_draft_report.txt")
+
+	if ver == "" {
+		for _, out := range []android.WritablePath{m.mapping, m.compat, m.ignore} {
+			android.WriteFileRule(ctx, out, "")
+		}
+		android.WriteFileRule(ctx, m.report, "no compat versions to draft compat files for")
+		return
+	}
+
+	current := m.outputFileOfDep(ctx, compatDraftCurrentTag)
+	prebuilt := m.outputFileOfDep(ctx, compatDraftPrebuiltTag)
+	if ctx.Failed() {
+		return
+	}
+
+	rule := android.NewRuleBuilder(pctx, ctx)
+	cmd := rule.Command().BuiltTool("compat_mapping_draft").
+		FlagWithInput("-c ", current).
+		FlagWithInput("-p ", prebuilt).
+		FlagWithArg("-v ", ver).
+		FlagWithOutput("--mapping ", m.mapping).
+		FlagWithOutput("--compat ", m.compat).
+		FlagWithOutput("--ignore ", m.ignore).
+		FlagWithOutput("--report ", m.report)
+
+	if latest != "" {
+		cmd.FlagWithArg("--latest-version ", latest)
+		compatDir := []string{ctx.ModuleDir(), "private", "compat", latest}
+		if path := android.ExistentPathForSource(ctx, append(compatDir, latest+".cil")...); path.Valid() {
+			cmd.FlagWithInput("--latest-mapping ", path.Path())
+		}
+		if path := android.ExistentPathForSource(ctx, append(compatDir, latest+".ignore.cil")...); path.Valid() {
+			cmd.FlagWithInput("--latest-ignore ", path.Path())
+		}
+	}
+
+	rule.Build("compat_draft", "Drafting compat files for "+ver)
+}
+
+func (m *compatDraft) OutputFiles(tag string) (android.Paths, error) {
+	switch tag {
+	case "":
+		return android.Paths{m.mapping, m.compat, m.ignore, m.report}, nil
+	case ".mapping":
+		return android.Paths{m.mapping}, nil
+	case ".compat":
+		return android.Paths{m.compat}, nil
+	case ".ignore":
+		return android.Paths{m.ignore}, nil
+	case ".report":
+		return android.Paths{m.report}, nil
+	}
+	return nil, fmt.Errorf("unsupported module reference tag %q", tag)
+}
+
+var _ android.OutputFileProducer = (*compatDraft)(nil)
@@ -0,0 +1,85 @@
+// Copyright 2024 The Android Open Source Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selinux
+
+import (
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+)
+
+type fileContextsProperties struct {
+	// Whether to also build a precompiled file_contexts.bin with sefcontext_compile, which is
+	// installed next to the text file and can be referenced with the ".bin" tag. libselinux
+	// prefers the binary file if it's newer than the text file, which saves compiling regexes at
+	// boot. Defaults to false.
+	//
+	// Precompiled regexes are tied to the exact PCRE2 version: libselinux on the device rejects
+	// them if they were compiled with a different PCRE2 version. The host sefcontext_compile and
+	// the target libselinux are both built with external/pcre, so they match as long as both are
+	// built from the same tree. Regexes are also tied to the pointer size and endianness of the
+	// reader, so they're omitted for 32-bit devices.
+	Compile_binary *bool
+
+	// Directories which catch-all specs (e.g. "/vendor/foo(/.*)?") are expected to be under, e.g.
+	// ["/vendor", "/odm"]. The linter reports catch-all specs which cover one of these
+	// directories as a whole, or which aren't under any of them. If empty, catch-all specs
+	// aren't checked.
+	Lint_roots []string
+
+	// Whether findings of the linter fail the build. Otherwise they're only written to the lint
+	// report, which can be referenced with the ".lint" tag. Defaults to false.
+	Lint_strict *bool
+}
+
+var _64BitDeviceArches = []string{"arm64", "riscv64", "x86_64"}
+
+// lintFileContexts runs file_contexts_lint on the given file_contexts, which reports overly broad
+// catch-all specs, specs which may backtrack excessively, and specs shadowed by later ones. It
+// returns a copy of the input, which is only produced once the lint passes, and the lint report.
+func (m *selinuxContextsModule) lintFileContexts(ctx android.ModuleContext, input android.Path) (android.Path, android.Path) {
+	report := pathForModuleOut(ctx, "lint", m.stem()+".txt")
+	out := pathForModuleOut(ctx, "linted", m.stem())
+
+	rule := android.NewRuleBuilder(pctx, ctx)
+	cmd := rule.Command().BuiltTool("file_contexts_lint").
+		FlagWithOutput("-o ", report).
+		FlagForEachArg("-r ", m.fileProperties.Lint_roots)
+	if proptools.Bool(m.fileProperties.Lint_strict) {
+		cmd.Flag("--strict")
+	}
+	cmd.Input(input)
+	rule.Command().Text("cp -f").Input(input).Output(out)
+	rule.Build("file_contexts_lint", "linting file_contexts: "+m.Name())
+	return out, report
+}
+
+// compileFileContexts compiles the given file_contexts into file_contexts.bin.
+func (m *selinuxContextsModule) compileFileContexts(ctx android.ModuleContext, input android.Path) android.Path {
+	out := pathForModuleOut(ctx, m.stem()+".bin")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	cmd := rule.Command().BuiltTool("sefcontext_compile").FlagWithOutput("-o ", out)
+	if !android.InList(ctx.DeviceConfig().DeviceArch(), _64BitDeviceArches) {
+		cmd.Flag("-r") // omit precompiled regexes
+	}
+	cmd.Input(input)
+
+	rule.Build("file_contexts_bin", "compiling file_contexts: "+m.Name())
+	return out
+}
+
+func (m *selinuxContextsModule) shouldCompileFileContexts() bool {
+	return proptools.Bool(m.fileProperties.Compile_binary)
+}
@@ -0,0 +1,197 @@
+// Copyright 2024 The Android Open Source Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selinux
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("se_freeze_snapshot", freezeSnapshotFactory)
+}
+
+type freezeSnapshotProperties struct {
+	// Mapping file of the plat policy, e.g. ":plat_mapping_file".
+	Plat_mapping_file *string `android:"path"`
+
+	// Mapping file of the system_ext policy, e.g. ":system_ext_mapping_file".
+	System_ext_mapping_file *string `android:"path"`
+
+	// Mapping file of the product policy, e.g. ":product_mapping_file".
+	Product_mapping_file *string `android:"path"`
+}
+
+type freezeSnapshot struct {
+	android.ModuleBase
+	properties freezeSnapshotProperties
+
+	snapshot android.ModuleOutPath
+}
+
+// se_freeze_snapshot zips everything which freezing the current sepolicy version adds to the source
+// tree, with paths relative to the top of the tree:
+//
+//   - the public and private policy of plat, system_ext and product, copied to
+//     <dir>/prebuilts/api/<ver>/{public,private}, where se_build_files looks for
+//     .plat_public_<ver>, .system_ext_private_<ver> and so on. Contexts files are part of the
+//     private policy.
+//   - prebuilts/api/<ver>/Android.bp of this directory, which builds the prebuilt policy.
+//   - for each partition, the mapping file <ver>.cil along with an empty <ver>.compat.cil and
+//     <ver>.ignore.cil, in private/compat/<ver>.
+//
+// Android.bp and the compat files come from the templates of the compat_templates tool, which
+// sepolicy_generate_compat and se_compat_draft share. Unzipping the snapshot at the top of the tree
+// finalizes the version.
+func freezeSnapshotFactory() android.Module {
+	m := &freezeSnapshot{}
+	m.AddProperties(&m.properties)
+	android.InitAndroidArchModule(m, android.DeviceSupported, android.MultilibCommon)
+	return m
+}
+
+func (m *freezeSnapshot) DepsMutator(ctx android.BottomUpMutatorContext) {
+	// do nothing
+}
+
+// snapshotPartition describes where a partition's policy is, and where its snapshot goes.
+type snapshotPartition struct {
+	name           string
+	publicDirs     []string
+	privateDirs    []string
+	prebuiltApiDir string
+	mappingFile    *string
+}
+
+func (m *freezeSnapshot) partitions(ctx android.ModuleContext) []snapshotPartition {
+	sepolicyDir := ctx.ModuleDir()
+	return []snapshotPartition{
+		{
+			name:           "plat",
+			publicDirs:     []string{filepath.Join(sepolicyDir, "public")},
+			privateDirs:    []string{filepath.Join(sepolicyDir, "private")},
+			prebuiltApiDir: sepolicyDir,
+			mappingFile:    m.properties.Plat_mapping_file,
+		},
+		{
+			name:           "system_ext",
+			publicDirs:     ctx.DeviceConfig().SystemExtPublicSepolicyDirs(),
+			privateDirs:    ctx.DeviceConfig().SystemExtPrivateSepolicyDirs(),
+			prebuiltApiDir: ctx.DeviceConfig().SystemExtSepolicyPrebuiltApiDir(),
+			mappingFile:    m.properties.System_ext_mapping_file,
+		},
+		{
+			name:           "product",
+			publicDirs:     ctx.Config().ProductPublicSepolicyDirs(),
+			privateDirs:    ctx.Config().ProductPrivateSepolicyDirs(),
+			prebuiltApiDir: ctx.DeviceConfig().ProductSepolicyPrebuiltApiDir(),
+			mappingFile:    m.properties.Product_mapping_file,
+		},
+	}
+}
+
+// addDirs zips the files of dirs to dest, and returns the zipped files.
+func addDirs(ctx android.ModuleContext, cmd *android.RuleBuilderCommand, dest string, dirs []string) android.Paths {
+	var srcs []string
+	for _, dir := range dirs {
+		cmd.FlagWithArg("-P ", dest).FlagWithArg("-C ", dir).FlagWithArg("-D ", dir)
+		glob, err := ctx.GlobWithDeps(dir+"/**/*", nil)
+		if err != nil {
+			ctx.ModuleErrorf("failed to glob sepolicy dir %q: %s", dir, err.Error())
+			continue
+		}
+		srcs = append(srcs, glob...)
+	}
+	sort.Strings(srcs)
+	return android.PathsForSource(ctx, srcs)
+}
+
+// addFile zips file to dest/name.
+func addFile(ctx android.ModuleContext, cmd *android.RuleBuilderCommand, dest, name string, file android.Path) {
+	if file.Base() != name {
+		ctx.ModuleErrorf("%q must be named %q to be zipped to %q", file, name, dest)
+		return
+	}
+	cmd.FlagWithArg("-P ", dest).FlagWithArg("-C ", filepath.Dir(file.String())).FlagWithInput("-f ", file)
+}
+
+func (m *freezeSnapshot) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	ver := ctx.DeviceConfig().PlatformSepolicyVersion()
+	m.snapshot = android.PathForModuleOut(ctx, ver+"_sepolicy_snapshot.zip")
+
+	// Files which freezing adds besides copies of the policy, written by compat_templates.
+	templates := android.NewRuleBuilder(pctx, ctx)
+	bp := android.PathForModuleOut(ctx, "Android.bp")
+	templates.Command().BuiltTool("compat_templates").
+		FlagWithArg("-v ", ver).
+		FlagWithOutput("--prebuilt-api-bp ", bp)
+
+	rule := android.NewRuleBuilder(pctx, ctx)
+	cmd := rule.Command().BuiltTool("soong_zip").
+		FlagWithOutput("-o ", m.snapshot)
+
+	var implicits android.Paths
+	for _, p := range m.partitions(ctx) {
+		if len(p.publicDirs) == 0 && len(p.privateDirs) == 0 {
+			continue
+		}
+		if p.prebuiltApiDir == "" {
+			ctx.ModuleErrorf("%s policy is set, but the directory of its prebuilt api isn't", p.name)
+			continue
+		}
+
+		prebuiltDir := filepath.Join(p.prebuiltApiDir, "prebuilts", "api", ver)
+		implicits = append(implicits, addDirs(ctx, cmd, filepath.Join(prebuiltDir, "public"), p.publicDirs)...)
+		implicits = append(implicits, addDirs(ctx, cmd, filepath.Join(prebuiltDir, "private"), p.privateDirs)...)
+
+		if len(p.privateDirs) == 0 {
+			continue
+		}
+		if p.mappingFile == nil {
+			ctx.ModuleErrorf("%s_mapping_file must be set to snapshot %s policy", p.name, p.name)
+			continue
+		}
+		compatDir := filepath.Join(p.privateDirs[0], "compat", ver)
+		addFile(ctx, cmd, compatDir, ver+".cil", android.PathForModuleSrc(ctx, *p.mappingFile))
+
+		compatCil := android.PathForModuleOut(ctx, p.name, ver+".compat.cil")
+		ignoreCil := android.PathForModuleOut(ctx, p.name, ver+".ignore.cil")
+		templates.Command().BuiltTool("compat_templates").
+			FlagWithArg("-v ", ver).
+			FlagWithOutput("--compat ", compatCil).
+			FlagWithOutput("--ignore ", ignoreCil)
+		addFile(ctx, cmd, compatDir, ver+".compat.cil", compatCil)
+		addFile(ctx, cmd, compatDir, ver+".ignore.cil", ignoreCil)
+	}
+
+	addFile(ctx, cmd, filepath.Join(ctx.ModuleDir(), "prebuilts", "api", ver), "Android.bp", bp)
+
+	templates.Build("freeze_snapshot_templates", "Writing freeze templates "+ver)
+	cmd.Implicits(implicits)
+	rule.Build("freeze_snapshot", "Zipping sepolicy snapshot "+ver)
+}
+
+func (m *freezeSnapshot) OutputFiles(tag string) (android.Paths, error) {
+	switch tag {
+	case "":
+		return android.Paths{m.snapshot}, nil
+	}
+	return nil, fmt.Errorf("unsupported module reference tag %q", tag)
+}
+
+var _ android.OutputFileProducer = (*freezeSnapshot)(nil)
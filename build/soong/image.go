@@ -0,0 +1,140 @@
+// Copyright 2024 The Android Open Source Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selinux
+
+import (
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+)
+
+var (
+	// Variants for images other than the core image depend on the core variant with this tag to
+	// reuse its outputs.
+	reuseCoreVariantDepTag = dependencyTag{name: "reuseCoreVariant"}
+)
+
+type imageProperties struct {
+	// Make this module available when building for recovery
+	Recovery_available *bool
+
+	// Make this module available when building for ramdisk
+	Ramdisk_available *bool
+
+	// Make this module available when building for vendor ramdisk
+	Vendor_ramdisk_available *bool
+
+	// Make this module available when building for debug ramdisk
+	Debug_ramdisk_available *bool
+}
+
+// imageVariants implements android.ImageInterface for modules which are built for the core image
+// and may also be available for recovery or ramdisks with e.g. `recovery_available: true`, or
+// which are built for one of those images only with e.g. `recovery: true`. Modules embedding it
+// must override the InstallIn* methods of ModuleBase to check the image variant instead.
+type imageVariants struct {
+	imageProperties imageProperties
+	module          *android.ModuleBase
+}
+
+func (v *imageVariants) initImageVariants(module *android.ModuleBase) {
+	v.module = module
+	module.AddProperties(&v.imageProperties)
+}
+
+// onlyInOtherImage returns whether the module is built only for an image other than the core
+// image. ModuleBase.InstallIn* methods check the properties rather than the image variant.
+func (v *imageVariants) onlyInOtherImage() bool {
+	return v.module.InstallInRecovery() || v.module.InstallInRamdisk() ||
+		v.module.InstallInVendorRamdisk() || v.module.InstallInDebugRamdisk()
+}
+
+// inOtherImage returns whether this is a variant for an image other than the core image.
+func (v *imageVariants) inOtherImage() bool {
+	return v.module.InRecovery() || v.module.InRamdisk() || v.module.InVendorRamdisk() ||
+		v.module.InDebugRamdisk()
+}
+
+// reusesCoreVariant returns whether this variant reuses outputs of the core variant instead of
+// building them.
+func (v *imageVariants) reusesCoreVariant() bool {
+	return v.inOtherImage() && !v.onlyInOtherImage()
+}
+
+func (v *imageVariants) addReuseCoreVariantDependency(ctx android.BottomUpMutatorContext) {
+	if v.reusesCoreVariant() {
+		ctx.AddFarVariationDependencies([]blueprint.Variation{
+			{Mutator: "image", Variation: android.CoreVariation},
+		}, reuseCoreVariantDepTag, ctx.ModuleName())
+	}
+}
+
+// imageSubName returns the suffix which tells this variant apart from the core variant in Make.
+func (v *imageVariants) imageSubName() string {
+	if !v.reusesCoreVariant() {
+		return ""
+	}
+	switch {
+	case v.module.InRecovery():
+		return ".recovery"
+	case v.module.InRamdisk():
+		return ".ramdisk"
+	case v.module.InVendorRamdisk():
+		return ".vendor_ramdisk"
+	default:
+		return ".debug_ramdisk"
+	}
+}
+
+func (v *imageVariants) ImageMutatorBegin(ctx android.BaseModuleContext) {
+	check := func(available *bool, installs bool, image string) {
+		if proptools.Bool(available) && installs {
+			ctx.PropertyErrorf(image+"_available",
+				"doesn't make sense at the same time as `%s: true`", image)
+		}
+	}
+	check(v.imageProperties.Recovery_available, v.module.InstallInRecovery(), "recovery")
+	check(v.imageProperties.Ramdisk_available, v.module.InstallInRamdisk(), "ramdisk")
+	check(v.imageProperties.Vendor_ramdisk_available, v.module.InstallInVendorRamdisk(), "vendor_ramdisk")
+	check(v.imageProperties.Debug_ramdisk_available, v.module.InstallInDebugRamdisk(), "debug_ramdisk")
+}
+
+func (v *imageVariants) CoreVariantNeeded(ctx android.BaseModuleContext) bool {
+	return !v.onlyInOtherImage()
+}
+
+func (v *imageVariants) RamdiskVariantNeeded(ctx android.BaseModuleContext) bool {
+	return v.module.InstallInRamdisk() || proptools.Bool(v.imageProperties.Ramdisk_available)
+}
+
+func (v *imageVariants) VendorRamdiskVariantNeeded(ctx android.BaseModuleContext) bool {
+	return v.module.InstallInVendorRamdisk() || proptools.Bool(v.imageProperties.Vendor_ramdisk_available)
+}
+
+func (v *imageVariants) DebugRamdiskVariantNeeded(ctx android.BaseModuleContext) bool {
+	return v.module.InstallInDebugRamdisk() || proptools.Bool(v.imageProperties.Debug_ramdisk_available)
+}
+
+func (v *imageVariants) RecoveryVariantNeeded(ctx android.BaseModuleContext) bool {
+	return v.module.InstallInRecovery() || proptools.Bool(v.imageProperties.Recovery_available)
+}
+
+func (v *imageVariants) ExtraImageVariations(ctx android.BaseModuleContext) []string {
+	return nil
+}
+
+func (v *imageVariants) SetImageVariation(ctx android.BaseModuleContext, variation string, module android.Module) {
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 The Android Open Source Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selinux
+
+import (
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("se_init_rc_test", initRcTestFactory)
+}
+
+type initRcTestProperties struct {
+	// init .rc files installed on the partition.
+	Srcs []string `android:"path"`
+
+	// file_contexts of all partitions, in the order libselinux loads them (plat, system_ext,
+	// product, vendor, odm), e.g. [":plat_file_contexts", ":vendor_file_contexts"].
+	File_contexts []string `android:"path"`
+
+	// Precompiled sepolicy binary of the device, e.g. ":precompiled_sepolicy".
+	Sepolicy *string `android:"path"`
+
+	// Names of services which are known not to start in their own domain.
+	Allowed_services []string
+}
+
+type initRcTestModule struct {
+	android.ModuleBase
+
+	properties    initRcTestProperties
+	testTimestamp android.ModuleOutPath
+}
+
+// se_init_rc_test checks that init can start every service of the given .rc files under enforcing
+// mode: either its seclabel names a domain, or its binary is labeled with an exec_type which init
+// transitions from, typically with init_daemon_domain(). The .rc files are device specific, so
+// there's no instance in this project; devices opt in by defining one listing their .rc files.
+func initRcTestFactory() android.Module {
+	m := &initRcTestModule{}
+	m.AddProperties(&m.properties)
+	android.InitAndroidArchModule(m, android.DeviceSupported, android.MultilibCommon)
+	return m
+}
+
+func (m *initRcTestModule) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	if len(m.properties.Srcs) == 0 {
+		ctx.PropertyErrorf("srcs", "can't be empty")
+		return
+	}
+	if len(m.properties.File_contexts) == 0 {
+		ctx.PropertyErrorf("file_contexts", "can't be empty")
+		return
+	}
+	if proptools.String(m.properties.Sepolicy) == "" {
+		ctx.PropertyErrorf("sepolicy", "can't be empty")
+		return
+	}
+
+	m.testTimestamp = android.PathForModuleOut(ctx, "timestamp")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().BuiltTool("init_rc_check").
+		FlagWithInput("-p ", android.PathForModuleSrc(ctx, proptools.String(m.properties.Sepolicy))).
+		FlagForEachInput("-f ", android.PathsForModuleSrc(ctx, m.properties.File_contexts)).
+		FlagForEachArg("--allow ", m.properties.Allowed_services).
+		Inputs(android.PathsForModuleSrc(ctx, m.properties.Srcs))
+	rule.Command().Text("touch").Output(m.testTimestamp)
+	rule.Build("init_rc_test", "checking init services: "+ctx.ModuleName())
+}
+
+func (m *initRcTestModule) AndroidMkEntries() []android.AndroidMkEntries {
+	return []android.AndroidMkEntries{android.AndroidMkEntries{
+		Class: "FAKE",
+		// OutputFile is needed, even though BUILD_PHONY_PACKAGE doesn't use it.
+		// Without OutputFile this module won't be exported to Makefile.
+		OutputFile: android.OptionalPathForPath(m.testTimestamp),
+		Include:    "$(BUILD_PHONY_PACKAGE)",
+		ExtraEntries: []android.AndroidMkExtraEntriesFunc{
+			func(ctx android.AndroidMkExtraEntriesContext, entries *android.AndroidMkEntries) {
+				entries.SetString("LOCAL_ADDITIONAL_DEPENDENCIES", m.testTimestamp.String())
+			},
+		},
+	}}
+}
@@ -0,0 +1,245 @@
+// Copyright 2024 The Android Open Source Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selinux
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+)
+
+func init() {
+	ctx := android.InitRegistrationContext
+	ctx.RegisterModuleType("se_label_manifest", labelManifestFactory)
+	ctx.RegisterParallelSingletonModuleType("se_installed_files", installedFilesFactory)
+}
+
+type labelManifestProperties struct {
+	// file_contexts of all partitions, in the order libselinux loads them (plat, system_ext,
+	// product, vendor, odm), e.g. [":plat_file_contexts", ":vendor_file_contexts"].
+	File_contexts []string `android:"path"`
+
+	// List of files installed to the partition, one path per line, e.g.
+	// ":se_installed_files{.vendor}". Lines may be prefixed with the file size, like
+	// installed-files-*.txt. Relative paths are relative to partition_root. Exactly one of
+	// installed_files and staged_dir must be set.
+	Installed_files *string `android:"path"`
+
+	// Partition directory in the source tree, e.g. an extracted prebuilt image, whose files are
+	// labeled as if it was mounted at partition_root. Unlike installed_files, file types are
+	// known, so specs restricted to a file type match.
+	Staged_dir *string
+
+	// Path where the partition is mounted on the device, e.g. "/vendor". Defaults to "/".
+	Partition_root *string
+
+	// Labels which files get when no specific file_contexts entry matches them. Files with these
+	// labels are listed in the defaults report. Defaults to ["system_file", "vendor_file"].
+	Default_labels []string
+
+	// Whether to fail the build if an executable gets one of the default labels, instead of only
+	// listing it in the executables report. Such executables have no exec type, so init can't
+	// start them in their own domain. Without a staged_dir, files in bin and xbin directories are
+	// considered executables, including symlinks and shell tools, so devices should only opt in
+	// along with an allowlist. Defaults to false.
+	Check_executables *bool
+
+	// Regexes of executables which may get a default label on purpose, e.g. "/vendor/bin/foo\\.sh".
+	Executable_allowlist []string
+}
+
+type labelManifestModule struct {
+	android.ModuleBase
+
+	properties        labelManifestProperties
+	manifest          android.Path
+	defaultsReport    android.Path
+	executablesReport android.Path
+}
+
+// se_label_manifest computes the label each file of a partition gets from file_contexts, with the
+// same matching semantics as selabel_lookup. The manifest has a "<path> <context>" line for each
+// file, and the defaults report, which can be referenced with the ".defaults" tag, lists files
+// which only get a default label such as vendor_file. The executables report, which can be
+// referenced with the ".executables" tag, lists executables among them. With check_executables,
+// they fail the build.
+func labelManifestFactory() android.Module {
+	m := &labelManifestModule{}
+	m.AddProperties(&m.properties)
+	android.InitAndroidArchModule(m, android.DeviceSupported, android.MultilibCommon)
+	return m
+}
+
+func (m *labelManifestModule) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	if len(m.properties.File_contexts) == 0 {
+		ctx.PropertyErrorf("file_contexts", "can't be empty")
+		return
+	}
+
+	installedFiles := proptools.String(m.properties.Installed_files)
+	stagedDir := proptools.String(m.properties.Staged_dir)
+	if (installedFiles == "") == (stagedDir == "") {
+		ctx.ModuleErrorf("exactly one of installed_files and staged_dir must be set")
+		return
+	}
+
+	m.manifest = android.PathForModuleOut(ctx, m.Name()+".txt")
+	m.defaultsReport = android.PathForModuleOut(ctx, m.Name()+"_defaults.txt")
+	m.executablesReport = android.PathForModuleOut(ctx, m.Name()+"_executables.txt")
+
+	rule := android.NewRuleBuilder(pctx, ctx)
+	cmd := rule.Command().BuiltTool("label_manifest").
+		FlagForEachInput("-f ", android.PathsForModuleSrc(ctx, m.properties.File_contexts)).
+		FlagWithArg("--root ", proptools.StringDefault(m.properties.Partition_root, "/")).
+		FlagForEachArg("--default-label ", m.properties.Default_labels).
+		FlagWithOutput("-o ", m.manifest).
+		FlagWithOutput("--defaults-report ", m.defaultsReport).
+		FlagWithOutput("--executables-report ", m.executablesReport).
+		FlagForEachArg("--allow ", proptools.ShellEscapeList(m.properties.Executable_allowlist))
+	if proptools.Bool(m.properties.Check_executables) {
+		cmd.Flag("--check-executables")
+	}
+	if installedFiles != "" {
+		cmd.FlagWithInput("--installed-files ", android.PathForModuleSrc(ctx, installedFiles))
+	} else {
+		// Ninja only tracks the mtime of a directory, so depend on the files in it.
+		cmd.FlagWithArg("--staged-dir ", android.PathForModuleSrc(ctx, stagedDir).String()).
+			Implicits(android.PathsForModuleSrc(ctx, []string{stagedDir + "/**/*"}))
+	}
+	rule.Build("label_manifest", "computing file labels: "+ctx.ModuleName())
+}
+
+var _ android.OutputFileProducer = (*labelManifestModule)(nil)
+
+// Implements android.OutputFileProducer
+func (m *labelManifestModule) OutputFiles(tag string) (android.Paths, error) {
+	switch tag {
+	case "":
+		return android.Paths{m.manifest}, nil
+	case ".defaults":
+		return android.Paths{m.defaultsReport}, nil
+	case ".executables":
+		return android.Paths{m.executablesReport}, nil
+	}
+	return nil, fmt.Errorf("unsupported module reference tag %q", tag)
+}
+
+func (m *labelManifestModule) AndroidMkEntries() []android.AndroidMkEntries {
+	return []android.AndroidMkEntries{android.AndroidMkEntries{
+		Class: "FAKE",
+		// OutputFile is needed, even though BUILD_PHONY_PACKAGE doesn't use it.
+		// Without OutputFile this module won't be exported to Makefile.
+		OutputFile: android.OptionalPathForPath(m.manifest),
+		Include:    "$(BUILD_PHONY_PACKAGE)",
+		ExtraEntries: []android.AndroidMkExtraEntriesFunc{
+			func(ctx android.AndroidMkExtraEntriesContext, entries *android.AndroidMkEntries) {
+				entries.SetString("LOCAL_ADDITIONAL_DEPENDENCIES",
+					m.manifest.String()+" "+m.defaultsReport.String()+" "+m.executablesReport.String())
+			},
+		},
+	}}
+}
+
+// installedFilesPartitions are partitions whose installed files se_installed_files lists.
+var installedFilesPartitions = []string{"system", "system_ext", "product", "vendor", "odm"}
+
+// se_installed_files lists the files Soong modules install to each partition, relative to the
+// partition, e.g. "bin/foo" for /vendor/bin/foo. The list of a partition can be referenced with its
+// name as the tag, e.g. ":se_installed_files{.vendor}", and used as installed_files of
+// se_label_manifest. The build.prop and default.prop files installed to a partition are
+// concatenated into one file, which can be referenced with the "_props" suffix, e.g.
+// ":se_installed_files{.vendor_props}", and used as prop_files of build_prop_contexts_test. Files
+// installed by Make modules aren't listed.
+func installedFilesFactory() android.SingletonModule {
+	m := &installedFilesModule{}
+	android.InitAndroidModule(m)
+	return m
+}
+
+type installedFilesModule struct {
+	android.SingletonModuleBase
+	lists map[string]android.ModuleOutPath
+	props map[string]android.ModuleOutPath
+}
+
+// isPropFile returns whether the installed file is a prop file which build_prop_contexts_test
+// checks.
+func isPropFile(installed android.InstallPath) bool {
+	return installed.Base() == "build.prop" || installed.Base() == "default.prop"
+}
+
+func (m *installedFilesModule) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	// The lists are written in GenerateSingletonBuildActions, after every module has generated
+	// its install rules.
+	m.lists = make(map[string]android.ModuleOutPath)
+	m.props = make(map[string]android.ModuleOutPath)
+	for _, partition := range installedFilesPartitions {
+		m.lists[partition] = android.PathForModuleOut(ctx, "installed-files-"+partition+".txt")
+		m.props[partition] = android.PathForModuleOut(ctx, "installed-"+partition+".prop")
+	}
+}
+
+func (m *installedFilesModule) GenerateSingletonBuildActions(ctx android.SingletonContext) {
+	files := make(map[string][]string)
+	props := make(map[string]android.Paths)
+	ctx.VisitAllModules(func(module android.Module) {
+		for _, installed := range module.FilesToInstall() {
+			files[installed.Partition()] = append(files[installed.Partition()], installed.Rel())
+			if isPropFile(installed) {
+				props[installed.Partition()] = append(props[installed.Partition()], installed)
+			}
+		}
+	})
+	for _, partition := range installedFilesPartitions {
+		list := android.SortedUniqueStrings(files[partition])
+		content := strings.Join(list, "\n")
+		if len(list) > 0 {
+			content += "\n"
+		}
+		android.WriteFileRule(ctx, m.lists[partition], content)
+
+		propFiles := android.SortedUniquePaths(props[partition])
+		if len(propFiles) == 0 {
+			android.WriteFileRule(ctx, m.props[partition], "")
+			continue
+		}
+		// Each file starts with a comment naming it, so that findings can be traced back.
+		rule := android.NewRuleBuilder(pctx, ctx)
+		cmd := rule.Command().Text("(")
+		for _, prop := range propFiles {
+			cmd.Text("echo '# " + prop.Rel() + "'; cat").Input(prop).Text(";")
+		}
+		cmd.Text(") >").Output(m.props[partition])
+		rule.Build("installed_props_"+partition, "listing prop files of "+partition)
+	}
+}
+
+var _ android.OutputFileProducer = (*installedFilesModule)(nil)
+
+// Implements android.OutputFileProducer
+func (m *installedFilesModule) OutputFiles(tag string) (android.Paths, error) {
+	if partition, ok := strings.CutSuffix(strings.TrimPrefix(tag, "."), "_props"); ok {
+		if props, ok := m.props[partition]; ok {
+			return android.Paths{props}, nil
+		}
+	}
+	if list, ok := m.lists[strings.TrimPrefix(tag, ".")]; ok && tag != "" {
+		return android.Paths{list}, nil
+	}
+	return nil, fmt.Errorf("unsupported module reference tag %q", tag)
+}
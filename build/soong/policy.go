@@ -98,6 +98,7 @@ type policyConf struct {
 	flaggableModuleBase
 
 	properties policyConfProperties
+	selectSrcs selectSrcsProperties
 
 	installSource android.Path
 	installPath   android.InstallPath
@@ -109,7 +110,7 @@ var _ flaggableModule = (*policyConf)(nil)
 // checkpolicy.
 func policyConfFactory() android.Module {
 	c := &policyConf{}
-	c.AddProperties(&c.properties)
+	c.AddProperties(&c.properties, &c.selectSrcs)
 	initFlaggableModule(c)
 	android.InitAndroidArchModule(c, android.DeviceSupported, android.MultilibCommon)
 	android.InitDefaultableModule(c)
@@ -129,6 +130,7 @@ func policyConfDefaultFactory() android.Module {
 	c := &policyConfDefaults{}
 	c.AddProperties(
 		&policyConfProperties{},
+		&selectSrcsProperties{},
 		&flaggableModuleProperties{},
 	)
 	android.InitDefaultsModule(c)
@@ -237,12 +239,13 @@ func (c *policyConf) transformPolicyToConf(ctx android.ModuleContext) android.Ou
 	conf := pathForModuleOut(ctx, c.stem())
 	rule := android.NewRuleBuilder(pctx, ctx)
 
+	flags := c.getBuildFlags(ctx)
 	srcs := android.PathsForModuleSrc(ctx, c.properties.Srcs)
+	srcs = append(srcs, flaggedSrcs(ctx, &c.selectSrcs, flags)...)
 	sort.SliceStable(srcs, func(x, y int) bool {
 		return findPolicyConfOrder(srcs[x].Base()) < findPolicyConfOrder(srcs[y].Base())
 	})
 
-	flags := c.getBuildFlags(ctx)
 	rule.Command().Tool(ctx.Config().PrebuiltBuildTool(ctx, "m4")).
 		Flag("--fatal-warnings").
 		FlagForEachArg("-D ", ctx.DeviceConfig().SepolicyM4Defs()).
@@ -272,6 +275,7 @@ func (c *policyConf) transformPolicyToConf(ctx android.ModuleContext) android.Ou
 
 func (c *policyConf) DepsMutator(ctx android.BottomUpMutatorContext) {
 	c.flagDeps(ctx)
+	c.properties.Srcs = append(c.properties.Srcs, selectSrcs(ctx, &c.selectSrcs)...)
 }
 
 func (c *policyConf) GenerateAndroidBuildActions(ctx android.ModuleContext) {
@@ -342,6 +346,7 @@ type policyCilProperties struct {
 
 type policyCil struct {
 	android.ModuleBase
+	imageVariants
 
 	properties policyCilProperties
 
@@ -354,10 +359,38 @@ type policyCil struct {
 func policyCilFactory() android.Module {
 	c := &policyCil{}
 	c.AddProperties(&c.properties)
+	c.initImageVariants(&c.ModuleBase)
 	android.InitAndroidArchModule(c, android.DeviceSupported, android.MultilibCommon)
 	return c
 }
 
+// The cil file is installed at the root of recovery and ramdisks, rather than under /system.
+func (c *policyCil) InstallInRoot() bool {
+	return c.inOtherImage()
+}
+
+// ModuleBase.InstallIn* methods check the properties, e.g. `debug_ramdisk: true`. The cil file is
+// also installed to the images which it's only available for.
+func (c *policyCil) InstallInRecovery() bool {
+	return c.InRecovery()
+}
+
+func (c *policyCil) InstallInRamdisk() bool {
+	return c.InRamdisk()
+}
+
+func (c *policyCil) InstallInVendorRamdisk() bool {
+	return c.InVendorRamdisk()
+}
+
+func (c *policyCil) InstallInDebugRamdisk() bool {
+	return c.InDebugRamdisk()
+}
+
+func (c *policyCil) DepsMutator(ctx android.BottomUpMutatorContext) {
+	c.addReuseCoreVariantDependency(ctx)
+}
+
 func (c *policyCil) Installable() bool {
 	return proptools.BoolDefault(c.properties.Installable, true)
 }
@@ -426,24 +459,31 @@ func (c *policyCil) compileConfToCil(ctx android.ModuleContext, conf android.Pat
 }
 
 func (c *policyCil) GenerateAndroidBuildActions(ctx android.ModuleContext) {
-	if proptools.String(c.properties.Src) == "" {
-		ctx.PropertyErrorf("src", "must be specified")
-		return
-	}
-	conf := android.PathForModuleSrc(ctx, *c.properties.Src)
-	cil := c.compileConfToCil(ctx, conf)
-
 	if !c.Installable() {
 		c.SkipInstall()
 	}
 
-	if c.InstallInDebugRamdisk() {
-		// for userdebug_plat_sepolicy.cil
+	if c.inOtherImage() {
+		// e.g. userdebug_plat_sepolicy.cil at the root of the debug ramdisk
 		c.installPath = android.PathForModuleInstall(ctx)
 	} else {
 		c.installPath = android.PathForModuleInstall(ctx, "etc", "selinux")
 	}
-	c.installSource = cil
+
+	if c.reusesCoreVariant() {
+		if dep, ok := ctx.GetDirectDepWithTag(c.Name(), reuseCoreVariantDepTag).(*policyCil); ok {
+			c.installSource = dep.installSource
+			ctx.InstallFile(c.installPath, c.stem(), c.installSource)
+			return
+		}
+	}
+
+	if proptools.String(c.properties.Src) == "" {
+		ctx.PropertyErrorf("src", "must be specified")
+		return
+	}
+	conf := android.PathForModuleSrc(ctx, *c.properties.Src)
+	c.installSource = c.compileConfToCil(ctx, conf)
 	ctx.InstallFile(c.installPath, c.stem(), c.installSource)
 }
 
@@ -451,6 +491,7 @@ func (c *policyCil) AndroidMkEntries() []android.AndroidMkEntries {
 	return []android.AndroidMkEntries{android.AndroidMkEntries{
 		OutputFile: android.OptionalPathForPath(c.installSource),
 		Class:      "ETC",
+		SubName:    c.imageSubName(),
 		ExtraEntries: []android.AndroidMkExtraEntriesFunc{
 			func(ctx android.AndroidMkExtraEntriesContext, entries *android.AndroidMkEntries) {
 				entries.SetBool("LOCAL_UNINSTALLABLE_MODULE", !c.Installable())
@@ -469,6 +510,7 @@ func (c *policyCil) OutputFiles(tag string) (android.Paths, error) {
 }
 
 var _ android.OutputFileProducer = (*policyCil)(nil)
+var _ android.ImageInterface = (*policyCil)(nil)
 
 type policyBinaryProperties struct {
 	// Name of the output. Default is {module_name}
@@ -486,10 +528,20 @@ type policyBinaryProperties struct {
 
 	// List of domains that are allowed to be in permissive mode on user builds.
 	Permissive_domains_on_user_builds []string
+
+	// Outputs of checks which must pass before secilc runs, because they explain failures better
+	// than secilc does.
+	Prechecks []string `android:"path"`
+
+	// Whether to write the result of secilc to the output instead of failing the build. The output
+	// is then a text file whose first line is "passed" or "failed", followed by secilc's errors.
+	// Tests which report several policies at once use it. Defaults to false.
+	Record_result *bool
 }
 
 type policyBinary struct {
 	android.ModuleBase
+	imageVariants
 
 	properties policyBinaryProperties
 
@@ -502,12 +554,36 @@ type policyBinary struct {
 func policyBinaryFactory() android.Module {
 	c := &policyBinary{}
 	c.AddProperties(&c.properties)
+	c.initImageVariants(&c.ModuleBase)
 	android.InitAndroidArchModule(c, android.DeviceSupported, android.MultilibCommon)
 	return c
 }
 
+// The binary is installed at the root of recovery and ramdisks, rather than under /system.
 func (c *policyBinary) InstallInRoot() bool {
-	return c.InstallInRecovery()
+	return c.inOtherImage()
+}
+
+// ModuleBase.InstallIn* methods check the properties, e.g. `recovery: true`. The binary is also
+// installed to the images which it's only available for.
+func (c *policyBinary) InstallInRecovery() bool {
+	return c.InRecovery()
+}
+
+func (c *policyBinary) InstallInRamdisk() bool {
+	return c.InRamdisk()
+}
+
+func (c *policyBinary) InstallInVendorRamdisk() bool {
+	return c.InVendorRamdisk()
+}
+
+func (c *policyBinary) InstallInDebugRamdisk() bool {
+	return c.InDebugRamdisk()
+}
+
+func (c *policyBinary) DepsMutator(ctx android.BottomUpMutatorContext) {
+	c.addReuseCoreVariantDependency(ctx)
 }
 
 func (c *policyBinary) Installable() bool {
@@ -519,10 +595,33 @@ func (c *policyBinary) stem() string {
 }
 
 func (c *policyBinary) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	if !c.Installable() {
+		c.SkipInstall()
+	}
+
+	if c.inOtherImage() {
+		// install in root
+		c.installPath = android.PathForModuleInstall(ctx)
+	} else {
+		c.installPath = android.PathForModuleInstall(ctx, "etc", "selinux")
+	}
+
+	if c.reusesCoreVariant() {
+		if dep, ok := ctx.GetDirectDepWithTag(c.Name(), reuseCoreVariantDepTag).(*policyBinary); ok {
+			c.installSource = dep.installSource
+			ctx.InstallFile(c.installPath, c.stem(), c.installSource)
+			return
+		}
+	}
+
 	if len(c.properties.Srcs) == 0 {
 		ctx.PropertyErrorf("srcs", "must be specified")
 		return
 	}
+	if proptools.Bool(c.properties.Record_result) {
+		c.recordSecilcResult(ctx)
+		return
+	}
 	bin := pathForModuleOut(ctx, c.stem()+"_policy")
 	rule := android.NewRuleBuilder(pctx, ctx)
 	secilcCmd := rule.Command().BuiltTool("secilc").
@@ -532,7 +631,8 @@ func (c *policyBinary) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 		FlagWithArg("-c ", strconv.Itoa(PolicyVers)).
 		Inputs(android.PathsForModuleSrc(ctx, c.properties.Srcs)).
 		FlagWithOutput("-o ", bin).
-		FlagWithArg("-f ", os.DevNull)
+		FlagWithArg("-f ", os.DevNull).
+		Implicits(android.PathsForModuleSrc(ctx, c.properties.Prechecks))
 
 	if proptools.BoolDefault(c.properties.Ignore_neverallow, ctx.Config().SelinuxIgnoreNeverallows()) {
 		secilcCmd.Flag("-N")
@@ -580,24 +680,45 @@ func (c *policyBinary) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	rule.DeleteTemporaryFiles()
 	rule.Build("secilc", "Compiling cil files for "+ctx.ModuleName())
 
-	if !c.Installable() {
-		c.SkipInstall()
-	}
+	c.installSource = out
+	ctx.InstallFile(c.installPath, c.stem(), c.installSource)
+}
 
-	if c.InstallInRecovery() {
-		// install in root
-		c.installPath = android.PathForModuleInstall(ctx)
-	} else {
-		c.installPath = android.PathForModuleInstall(ctx, "etc", "selinux")
+// recordSecilcResult compiles srcs, and writes whether secilc passed to the output.
+func (c *policyBinary) recordSecilcResult(ctx android.ModuleContext) {
+	bin := pathForModuleOut(ctx, c.stem()+"_policy")
+	log := pathForModuleOut(ctx, c.stem()+"_secilc.log")
+	out := pathForModuleOut(ctx, c.stem()+"_result.txt")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	secilcCmd := rule.Command().Text("if").BuiltTool("secilc").
+		Flag("-m").                 // Multiple decls
+		FlagWithArg("-M ", "true"). // Enable MLS
+		Flag("-G").                 // expand and remove auto generated attributes
+		FlagWithArg("-c ", strconv.Itoa(PolicyVers)).
+		Inputs(android.PathsForModuleSrc(ctx, c.properties.Srcs)).
+		FlagWithOutput("-o ", bin).
+		FlagWithArg("-f ", os.DevNull).
+		Implicits(android.PathsForModuleSrc(ctx, c.properties.Prechecks))
+	if proptools.BoolDefault(c.properties.Ignore_neverallow, ctx.Config().SelinuxIgnoreNeverallows()) {
+		secilcCmd.Flag("-N")
 	}
+	secilcCmd.FlagWithOutput("> ", log).Text("2>&1; then echo passed >").Output(out).
+		Text("; else { echo failed; cat " + log.String() + "; } > " + out.String() + "; fi")
+	rule.Temporary(bin)
+	rule.Temporary(log)
+	rule.DeleteTemporaryFiles()
+	rule.Build("secilc", "Compiling cil files for "+ctx.ModuleName())
+
+	// The result isn't a policy, so it's never installed.
+	c.SkipInstall()
 	c.installSource = out
-	ctx.InstallFile(c.installPath, c.stem(), c.installSource)
 }
 
 func (c *policyBinary) AndroidMkEntries() []android.AndroidMkEntries {
 	return []android.AndroidMkEntries{android.AndroidMkEntries{
 		OutputFile: android.OptionalPathForPath(c.installSource),
 		Class:      "ETC",
+		SubName:    c.imageSubName(),
 		ExtraEntries: []android.AndroidMkExtraEntriesFunc{
 			func(ctx android.AndroidMkExtraEntriesContext, entries *android.AndroidMkEntries) {
 				entries.SetBool("LOCAL_UNINSTALLABLE_MODULE", !c.Installable())
@@ -616,3 +737,4 @@ func (c *policyBinary) OutputFiles(tag string) (android.Paths, error) {
 }
 
 var _ android.OutputFileProducer = (*policyBinary)(nil)
+var _ android.ImageInterface = (*policyBinary)(nil)
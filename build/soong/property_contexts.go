@@ -0,0 +1,58 @@
+// Copyright 2024 The Android Open Source Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selinux
+
+import (
+	"fmt"
+
+	"android/soong/android"
+)
+
+type propertyContextsProperties struct {
+	// Labels of properties declared by sysprop_library modules, in the form "<key>=<label>". The
+	// key is either the name of a sysprop module, e.g. "android.sysprop.AdbProperties", or a
+	// property name prefix ending with ".", e.g. "persist.adb.". Every property of sysprop APIs
+	// which a key matches gets an exact entry with its declared type, so that names and types
+	// don't need to be maintained twice. Prefix keys also get a prefix entry for the namespace.
+	Sysprop_labels []string
+}
+
+// syspropApiFiles returns current API files of all sysprop_library modules.
+func (m *selinuxContextsModule) syspropApiFiles(ctx android.ModuleContext) android.Paths {
+	var apiFiles android.Paths
+	ctx.VisitDirectDepsWithTag(syspropLibraryDepTag, func(c android.Module) {
+		i, ok := c.(interface{ CurrentSyspropApiFile() android.OptionalPath })
+		if !ok {
+			panic(fmt.Errorf("unknown dependency %q for %q", ctx.OtherModuleName(c), ctx.ModuleName()))
+		}
+		if api := i.CurrentSyspropApiFile(); api.Valid() {
+			apiFiles = append(apiFiles, api.Path())
+		}
+	})
+	return apiFiles
+}
+
+// generateSyspropContexts generates property_contexts entries for properties mapped by
+// sysprop_labels.
+func (m *selinuxContextsModule) generateSyspropContexts(ctx android.ModuleContext, apiFiles android.Paths) android.Path {
+	out := pathForModuleOut(ctx, "sysprop_property_contexts")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().BuiltTool("sysprop_contexts_gen").
+		FlagForEachInput("--api ", apiFiles).
+		FlagForEachArg("--label ", m.propertyProperties.Sysprop_labels).
+		FlagWithOutput("-o ", out)
+	rule.Build("sysprop_property_contexts", "generating property_contexts from sysprop: "+m.Name())
+	return out
+}
@@ -0,0 +1,130 @@
+// Copyright 2024 The Android Open Source Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selinux
+
+import (
+	"fmt"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+func init() {
+	ctx := android.InitRegistrationContext
+	ctx.RegisterParallelSingletonModuleType("seapp_contexts_test", seappContextsTestFactory)
+}
+
+type seappContextsTestProperties struct {
+	// seinfo values of apps signed with platform keys. Defaults to ["platform"].
+	Platform_seinfos []string
+}
+
+// seapp_contexts_test reports seapp_contexts entries of different partitions which match the same
+// apps with different domains, and which entry wins, across all seapp_contexts modules in the tree.
+// It fails when a vendor, odm or product entry overrides a system or system_ext entry for a
+// platform app.
+func seappContextsTestFactory() android.SingletonModule {
+	m := &seappContextsTestModule{}
+	m.AddProperties(&m.properties)
+	android.InitAndroidModule(m)
+	return m
+}
+
+type seappContextsTestModule struct {
+	android.SingletonModuleBase
+
+	properties    seappContextsTestProperties
+	testTimestamp android.ModuleOutPath
+	reportPath    android.ModuleOutPath
+}
+
+func (m *seappContextsTestModule) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	// The rule is built in GenerateSingletonBuildActions, after every seapp_contexts module has
+	// generated its output.
+	m.testTimestamp = android.PathForModuleOut(ctx, "timestamp")
+	m.reportPath = android.PathForModuleOut(ctx, "report.txt")
+}
+
+type seappContextsInfo struct {
+	// Partition which the seapp_contexts is installed to
+	Partition string
+	// Checked seapp_contexts
+	SeappContexts android.Path
+}
+
+var seappContextsProviderKey = blueprint.NewProvider[seappContextsInfo]()
+
+// seappPartition returns the partition which a seapp_contexts module is installed to.
+func seappPartition(m *selinuxContextsModule) string {
+	switch {
+	case m.SocSpecific():
+		return "vendor"
+	case m.DeviceSpecific():
+		return "odm"
+	case m.ProductSpecific():
+		return "product"
+	case m.SystemExtSpecific():
+		return "system_ext"
+	default:
+		return "system"
+	}
+}
+
+func (m *seappContextsTestModule) GenerateSingletonBuildActions(ctx android.SingletonContext) {
+	var files []string
+	var inputs android.Paths
+	ctx.VisitAllModules(func(module android.Module) {
+		info, ok := android.SingletonModuleProvider(ctx, module, seappContextsProviderKey)
+		if !ok {
+			return
+		}
+		files = append(files, fmt.Sprintf("%s=%s", info.Partition, info.SeappContexts.String()))
+		inputs = append(inputs, info.SeappContexts)
+	})
+
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().BuiltTool("seapp_contexts_analysis").
+		FlagForEachArg("-f ", files).
+		Implicits(inputs).
+		FlagForEachArg("--platform-seinfo ", m.properties.Platform_seinfos).
+		FlagWithOutput("-o ", m.reportPath)
+	rule.Command().Text("touch").Output(m.testTimestamp)
+	rule.Build("seapp_contexts_test", "checking seapp_contexts across partitions")
+}
+
+func (m *seappContextsTestModule) OutputFiles(tag string) (android.Paths, error) {
+	switch tag {
+	case "":
+		return android.Paths{m.reportPath}, nil
+	default:
+		return nil, fmt.Errorf("unsupported module reference tag %q", tag)
+	}
+}
+
+func (m *seappContextsTestModule) AndroidMkEntries() []android.AndroidMkEntries {
+	return []android.AndroidMkEntries{android.AndroidMkEntries{
+		Class: "FAKE",
+		// OutputFile is needed, even though BUILD_PHONY_PACKAGE doesn't use it.
+		// Without OutputFile this module won't be exported to Makefile.
+		OutputFile: android.OptionalPathForPath(m.testTimestamp),
+		Include:    "$(BUILD_PHONY_PACKAGE)",
+		ExtraEntries: []android.AndroidMkExtraEntriesFunc{
+			func(ctx android.AndroidMkExtraEntriesContext, entries *android.AndroidMkEntries) {
+				entries.SetString("LOCAL_ADDITIONAL_DEPENDENCIES", m.testTimestamp.String())
+			},
+		},
+	}}
+}
@@ -0,0 +1,103 @@
+// Copyright 2024 The Android Open Source Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selinux
+
+import (
+	"strings"
+
+	"android/soong/android"
+)
+
+type selectSrcsProperties struct {
+	// Additional sources which are appended to srcs only if the lunch target matches. For example,
+	// an Android.bp file could have:
+	//
+	//	file_contexts {
+	//		name: "plat_file_contexts",
+	//		srcs: ["file_contexts"],
+	//		select_srcs: {
+	//			hwaddress_sanitize: ["file_contexts_hwasan"],
+	//			build_flags: ["RELEASE_FOO:file_contexts_foo"],
+	//		},
+	//	}
+	Select_srcs struct {
+		// Added when the device is built with SANITIZE_TARGET=address.
+		Address_sanitize []string `android:"path"`
+
+		// Added when the device is built with SANITIZE_TARGET=hwaddress.
+		Hwaddress_sanitize []string `android:"path"`
+
+		// Added when the device is built with clang or gcov native coverage.
+		Native_coverage []string `android:"path"`
+
+		// Added when the device is built without dexpreopt (WITH_DEXPREOPT := false).
+		Without_dexpreopt []string `android:"path"`
+
+		// Added when the given release build flag is set to "true". Each entry has the form
+		// "<FLAG_NAME>:<src>", e.g. "RELEASE_AVF_ENABLE_DEVICE_ASSIGNMENT:vfio_file_contexts". Like
+		// is_flag_enabled, the flag must be declared by an se_flags module exported to one of the
+		// build_flags collectors of this module. Otherwise it's treated as unset.
+		Build_flags []string
+	}
+}
+
+// selectSrcs returns sources in props which are selected by the current lunch target, in the order
+// of the fields of selectSrcsProperties. This must be called from DepsMutator, so that values from
+// defaults modules are taken into account and selected sources still get path dependencies.
+// Sources of build_flags aren't returned, because build flags are only known after se_flags
+// collectors are built. See flaggedSrcs.
+func selectSrcs(ctx android.BottomUpMutatorContext, props *selectSrcsProperties) []string {
+	var srcs []string
+	sel := &props.Select_srcs
+
+	sanitizers := ctx.Config().SanitizeDevice()
+	if android.InList("address", sanitizers) {
+		srcs = append(srcs, sel.Address_sanitize...)
+	}
+	if android.InList("hwaddress", sanitizers) {
+		srcs = append(srcs, sel.Hwaddress_sanitize...)
+	}
+	if ctx.DeviceConfig().ClangCoverageEnabled() || ctx.DeviceConfig().GcovCoverageEnabled() {
+		srcs = append(srcs, sel.Native_coverage...)
+	}
+	if !ctx.DeviceConfig().WithDexpreopt() {
+		srcs = append(srcs, sel.Without_dexpreopt...)
+	}
+
+	for _, entry := range sel.Build_flags {
+		flag, src, ok := strings.Cut(entry, ":")
+		if !ok || flag == "" || src == "" {
+			ctx.PropertyErrorf("select_srcs.build_flags", "%q must have the form <FLAG_NAME>:<src>", entry)
+			continue
+		}
+		// Whether the source is selected isn't known yet, so always depend on it.
+		android.ExtractSourceDeps(ctx, &src)
+	}
+
+	return srcs
+}
+
+// flaggedSrcs returns paths of build_flags sources of props whose flag is "true" in flags, which
+// come from getBuildFlags.
+func flaggedSrcs(ctx android.ModuleContext, props *selectSrcsProperties, flags map[string]string) android.Paths {
+	var srcs android.Paths
+	for _, entry := range props.Select_srcs.Build_flags {
+		flag, src, ok := strings.Cut(entry, ":")
+		if ok && flags[flag] == "true" {
+			srcs = append(srcs, android.PathForModuleSrc(ctx, src))
+		}
+	}
+	return srcs
+}
@@ -32,6 +32,7 @@ type selinuxContextsProperties struct {
 	// Output file name. Defaults to module name
 	Stem *string
 
+	// Deprecated: use select_srcs.address_sanitize instead.
 	Product_variables struct {
 		Address_sanitize struct {
 			Srcs []string `android:"path"`
@@ -43,36 +44,56 @@ type selinuxContextsProperties struct {
 
 	// Whether the result context file is sorted with fc_sort or not.
 	Fc_sort *bool
-
-	// Make this module available when building for recovery
-	Recovery_available *bool
 }
 
 type seappProperties struct {
 	// Files containing neverallow rules.
 	Neverallow_files []string `android:"path"`
 
-	// Precompiled sepolicy binary file which will be fed to checkseapp.
+	// Precompiled sepolicy binary file which will be fed to checkseapp, or used to validate labels
+	// of apex_file_contexts.
 	Sepolicy *string `android:"path"`
+
+	// Rules on the domains which this partition's seapp_contexts may assign. Vendor and odm
+	// modules always forbid coredomain if PRODUCT_CHECK_VENDOR_SEAPP_VIOLATIONS is set.
+	Domain_rules struct {
+		// Attributes which assigned domains must not have, e.g. ["coredomain"].
+		Forbidden_attributes []string
+
+		// Attributes which assigned domains must all have, e.g. ["appdomain"].
+		Required_attributes []string
+
+		// Domains which are exempt from the rules above.
+		Exempt_domains []string
+	}
 }
 
 type selinuxContextsModule struct {
 	android.ModuleBase
 	android.DefaultableModuleBase
 	flaggableModuleBase
+	imageVariants
 
-	properties      selinuxContextsProperties
-	seappProperties seappProperties
-	build           func(ctx android.ModuleContext, inputs android.Paths) android.Path
-	deps            func(ctx android.BottomUpMutatorContext)
-	outputPath      android.Path
-	installPath     android.InstallPath
+	properties         selinuxContextsProperties
+	selectSrcs         selectSrcsProperties
+	seappProperties    seappProperties
+	apexProperties     apexFileContextsProperties
+	fileProperties     fileContextsProperties
+	propertyProperties propertyContextsProperties
+	build              func(ctx android.ModuleContext, inputs android.Paths) android.Path
+	deps               func(ctx android.BottomUpMutatorContext)
+	outputPath         android.Path
+	installPath        android.InstallPath
+
+	// Precompiled file_contexts.bin, if compile_binary is set.
+	binOutputPath android.Path
+	// Findings of file_contexts_lint.
+	lintReportPath android.Path
 }
 
 var _ flaggableModule = (*selinuxContextsModule)(nil)
 
 var (
-	reuseContextsDepTag  = dependencyTag{name: "reuseContexts"}
 	syspropLibraryDepTag = dependencyTag{name: "sysprop_library"}
 )
 
@@ -93,34 +114,41 @@ func init() {
 	android.RegisterModuleType("hwservice_contexts_test", hwserviceContextsTestFactory)
 	android.RegisterModuleType("service_contexts_test", serviceContextsTestFactory)
 	android.RegisterModuleType("vndservice_contexts_test", vndServiceContextsTestFactory)
+	android.RegisterModuleType("keystore2_key_contexts_test", keystore2KeyContextsTestFactory)
 }
 
+// Contexts are installed at the root of recovery and ramdisks, rather than under /system.
 func (m *selinuxContextsModule) InstallInRoot() bool {
-	return m.InRecovery()
+	return m.inOtherImage()
 }
 
+// ModuleBase.InstallIn* methods check the properties, e.g. `recovery: true`. Contexts are also
+// installed to the images which they're only available for.
 func (m *selinuxContextsModule) InstallInRecovery() bool {
-	// ModuleBase.InRecovery() checks the image variant
 	return m.InRecovery()
 }
 
-func (m *selinuxContextsModule) onlyInRecovery() bool {
-	// ModuleBase.InstallInRecovery() checks commonProperties.Recovery property
-	return m.ModuleBase.InstallInRecovery()
+func (m *selinuxContextsModule) InstallInRamdisk() bool {
+	return m.InRamdisk()
+}
+
+func (m *selinuxContextsModule) InstallInVendorRamdisk() bool {
+	return m.InVendorRamdisk()
+}
+
+func (m *selinuxContextsModule) InstallInDebugRamdisk() bool {
+	return m.InDebugRamdisk()
 }
 
 func (m *selinuxContextsModule) DepsMutator(ctx android.BottomUpMutatorContext) {
 	m.flagDeps(ctx)
+	m.appendSelectedSrcs(ctx)
 
 	if m.deps != nil {
 		m.deps(ctx)
 	}
 
-	if m.InRecovery() && !m.onlyInRecovery() {
-		ctx.AddFarVariationDependencies([]blueprint.Variation{
-			{Mutator: "image", Variation: android.CoreVariation},
-		}, reuseContextsDepTag, ctx.ModuleName())
-	}
+	m.addReuseCoreVariantDependency(ctx)
 }
 
 func (m *selinuxContextsModule) propertyContextsDeps(ctx android.BottomUpMutatorContext) {
@@ -134,39 +162,49 @@ func (m *selinuxContextsModule) stem() string {
 }
 
 func (m *selinuxContextsModule) GenerateAndroidBuildActions(ctx android.ModuleContext) {
-	if m.InRecovery() {
-		// Installing context files at the root of the recovery partition
+	if m.inOtherImage() {
+		// Installing context files at the root of the recovery partition or ramdisk
 		m.installPath = android.PathForModuleInstall(ctx)
 	} else {
 		m.installPath = android.PathForModuleInstall(ctx, "etc", "selinux")
 	}
 
-	if m.InRecovery() && !m.onlyInRecovery() {
-		dep := ctx.GetDirectDepWithTag(m.Name(), reuseContextsDepTag)
+	if m.reusesCoreVariant() {
+		dep := ctx.GetDirectDepWithTag(m.Name(), reuseCoreVariantDepTag)
 
 		if reuseDeps, ok := dep.(*selinuxContextsModule); ok {
 			m.outputPath = reuseDeps.outputPath
-			ctx.InstallFile(m.installPath, m.stem(), m.outputPath)
+			m.binOutputPath = reuseDeps.binOutputPath
+			m.lintReportPath = reuseDeps.lintReportPath
+			m.installOutputs(ctx)
 			return
 		}
 	}
 
-	m.outputPath = m.build(ctx, android.PathsForModuleSrc(ctx, m.properties.Srcs))
+	srcs := android.PathsForModuleSrc(ctx, m.properties.Srcs)
+	srcs = append(srcs, flaggedSrcs(ctx, &m.selectSrcs, m.getBuildFlags(ctx))...)
+	m.outputPath = m.build(ctx, srcs)
+	m.installOutputs(ctx)
+}
+
+func (m *selinuxContextsModule) installOutputs(ctx android.ModuleContext) {
 	ctx.InstallFile(m.installPath, m.stem(), m.outputPath)
+	if m.binOutputPath != nil {
+		ctx.InstallFile(m.installPath, m.stem()+".bin", m.binOutputPath)
+	}
 }
 
 func newModule() *selinuxContextsModule {
 	m := &selinuxContextsModule{}
 	m.AddProperties(
 		&m.properties,
+		&m.selectSrcs,
 		&m.seappProperties,
 	)
 	initFlaggableModule(m)
+	m.initImageVariants(&m.ModuleBase)
 	android.InitAndroidArchModule(m, android.DeviceSupported, android.MultilibCommon)
 	android.InitDefaultableModule(m)
-	android.AddLoadHook(m, func(ctx android.LoadHookContext) {
-		m.selinuxContextsHook(ctx)
-	})
 	return m
 }
 
@@ -183,36 +221,28 @@ func contextsDefaultsFactory() android.Module {
 	m := &contextsDefaults{}
 	m.AddProperties(
 		&selinuxContextsProperties{},
+		&selectSrcsProperties{},
 		&seappProperties{},
+		&fileContextsProperties{},
+		&propertyContextsProperties{},
 		&flaggableModuleProperties{},
+		&imageProperties{},
 	)
 	android.InitDefaultsModule(m)
 	return m
 }
 
-func (m *selinuxContextsModule) selinuxContextsHook(ctx android.LoadHookContext) {
-	// TODO: clean this up to use build/soong/android/variable.go after b/79249983
-	var srcs []string
-
-	for _, sanitize := range ctx.Config().SanitizeDevice() {
-		if sanitize == "address" {
-			srcs = append(srcs, m.properties.Product_variables.Address_sanitize.Srcs...)
-			break
-		}
-	}
-
-	m.properties.Srcs = append(m.properties.Srcs, srcs...)
+func (m *selinuxContextsModule) appendSelectedSrcs(ctx android.BottomUpMutatorContext) {
+	m.selectSrcs.Select_srcs.Address_sanitize = append(m.selectSrcs.Select_srcs.Address_sanitize,
+		m.properties.Product_variables.Address_sanitize.Srcs...)
+	m.properties.Srcs = append(m.properties.Srcs, selectSrcs(ctx, &m.selectSrcs)...)
 }
 
 func (m *selinuxContextsModule) AndroidMk() android.AndroidMkData {
-	nameSuffix := ""
-	if m.InRecovery() && !m.onlyInRecovery() {
-		nameSuffix = ".recovery"
-	}
 	return android.AndroidMkData{
 		Class:      "ETC",
 		OutputFile: android.OptionalPathForPath(m.outputPath),
-		SubName:    nameSuffix,
+		SubName:    m.imageSubName(),
 		Extra: []android.AndroidMkExtraFunc{
 			func(w io.Writer, outputFile android.Path) {
 				fmt.Fprintln(w, "LOCAL_MODULE_PATH :=", m.installPath.String())
@@ -222,40 +252,6 @@ func (m *selinuxContextsModule) AndroidMk() android.AndroidMkData {
 	}
 }
 
-func (m *selinuxContextsModule) ImageMutatorBegin(ctx android.BaseModuleContext) {
-	if proptools.Bool(m.properties.Recovery_available) && m.ModuleBase.InstallInRecovery() {
-		ctx.PropertyErrorf("recovery_available",
-			"doesn't make sense at the same time as `recovery: true`")
-	}
-}
-
-func (m *selinuxContextsModule) CoreVariantNeeded(ctx android.BaseModuleContext) bool {
-	return !m.ModuleBase.InstallInRecovery()
-}
-
-func (m *selinuxContextsModule) RamdiskVariantNeeded(ctx android.BaseModuleContext) bool {
-	return false
-}
-
-func (m *selinuxContextsModule) VendorRamdiskVariantNeeded(ctx android.BaseModuleContext) bool {
-	return false
-}
-
-func (m *selinuxContextsModule) DebugRamdiskVariantNeeded(ctx android.BaseModuleContext) bool {
-	return false
-}
-
-func (m *selinuxContextsModule) RecoveryVariantNeeded(ctx android.BaseModuleContext) bool {
-	return m.ModuleBase.InstallInRecovery() || proptools.Bool(m.properties.Recovery_available)
-}
-
-func (m *selinuxContextsModule) ExtraImageVariations(ctx android.BaseModuleContext) []string {
-	return nil
-}
-
-func (m *selinuxContextsModule) SetImageVariation(ctx android.BaseModuleContext, variation string, module android.Module) {
-}
-
 var _ android.ImageInterface = (*selinuxContextsModule)(nil)
 
 func (m *selinuxContextsModule) buildGeneralContexts(ctx android.ModuleContext, inputs android.Paths) android.Path {
@@ -322,11 +318,17 @@ func (m *selinuxContextsModule) buildFileContexts(ctx android.ModuleContext, inp
 	if m.properties.Remove_comment == nil {
 		m.properties.Remove_comment = proptools.BoolPtr(true)
 	}
-	return m.buildGeneralContexts(ctx, inputs)
+	out, report := m.lintFileContexts(ctx, m.buildGeneralContexts(ctx, inputs))
+	m.lintReportPath = report
+	if m.shouldCompileFileContexts() {
+		m.binOutputPath = m.compileFileContexts(ctx, out)
+	}
+	return out
 }
 
 func fileFactory() android.Module {
 	m := newModule()
+	m.AddProperties(&m.fileProperties)
 	m.build = m.buildFileContexts
 	return m
 }
@@ -397,6 +399,11 @@ func (m *selinuxContextsModule) checkVendorPropertyNamespace(ctx android.ModuleC
 }
 
 func (m *selinuxContextsModule) buildPropertyContexts(ctx android.ModuleContext, inputs android.Paths) android.Path {
+	apiFiles := m.syspropApiFiles(ctx)
+	if len(m.propertyProperties.Sysprop_labels) > 0 {
+		inputs = append(inputs, m.generateSyspropContexts(ctx, apiFiles))
+	}
+
 	// vendor/odm properties are enforced for devices launching with Android Q or later. So, if
 	// vendor/odm, make sure that only vendor/odm properties exist.
 	builtCtxFile := m.buildGeneralContexts(ctx, inputs)
@@ -407,17 +414,6 @@ func (m *selinuxContextsModule) buildPropertyContexts(ctx android.ModuleContext,
 		builtCtxFile = m.checkVendorPropertyNamespace(ctx, builtCtxFile)
 	}
 
-	var apiFiles android.Paths
-	ctx.VisitDirectDepsWithTag(syspropLibraryDepTag, func(c android.Module) {
-		i, ok := c.(interface{ CurrentSyspropApiFile() android.OptionalPath })
-		if !ok {
-			panic(fmt.Errorf("unknown dependency %q for %q", ctx.OtherModuleName(c), ctx.ModuleName()))
-		}
-		if api := i.CurrentSyspropApiFile(); api.Valid() {
-			apiFiles = append(apiFiles, api.Path())
-		}
-	})
-
 	// check compatibility with sysprop_library
 	if len(apiFiles) > 0 {
 		out := pathForModuleOut(ctx, ctx.ModuleName()+"_api_checked")
@@ -445,39 +441,38 @@ func (m *selinuxContextsModule) buildPropertyContexts(ctx android.ModuleContext,
 	return builtCtxFile
 }
 
-func (m *selinuxContextsModule) shouldCheckCoredomain(ctx android.ModuleContext) bool {
-	if !ctx.SocSpecific() && !ctx.DeviceSpecific() {
-		return false
+// forbiddenDomainAttributes returns attributes which domains assigned by this seapp_contexts must
+// not have.
+func (m *selinuxContextsModule) forbiddenDomainAttributes(ctx android.ModuleContext) []string {
+	attributes := m.seappProperties.Domain_rules.Forbidden_attributes
+	if (ctx.SocSpecific() || ctx.DeviceSpecific()) && ctx.DeviceConfig().CheckVendorSeappViolations() {
+		attributes = append([]string{"coredomain"}, attributes...)
 	}
-
-	return ctx.DeviceConfig().CheckVendorSeappViolations()
+	return android.FirstUniqueStrings(attributes)
 }
 
 func (m *selinuxContextsModule) buildSeappContexts(ctx android.ModuleContext, inputs android.Paths) android.Path {
 	neverallowFile := pathForModuleOut(ctx, "neverallow")
 	ret := pathForModuleOut(ctx, "checkseapp", m.stem())
 
-	// Step 1. Generate a M4 processed neverallow file
+	// Step 1. Extract neverallows from M4 processed neverallow files. Synclines let errors point
+	// at the original files.
 	flags := m.getBuildFlags(ctx)
 	m4NeverallowFile := pathForModuleOut(ctx, "neverallow.m4out")
 	rule := android.NewRuleBuilder(pctx, ctx)
 	rule.Command().
 		Tool(ctx.Config().PrebuiltBuildTool(ctx, "m4")).
 		Flag("--fatal-warnings").
+		Flag("-s").
 		FlagForEachArg("-D", ctx.DeviceConfig().SepolicyM4Defs()).
 		Flags(flagsToM4Macros(flags)).
 		Inputs(android.PathsForModuleSrc(ctx, m.seappProperties.Neverallow_files)).
 		FlagWithOutput("> ", m4NeverallowFile)
 
 	rule.Temporary(m4NeverallowFile)
-	rule.Command().
-		Text("( grep").
-		Flag("-ihe").
-		Text("'^neverallow'").
-		Input(m4NeverallowFile).
-		Text(">").
-		Output(neverallowFile).
-		Text("|| true )") // to make ninja happy even when result is empty
+	rule.Command().BuiltTool("seapp_neverallow_extract").
+		FlagWithOutput("-o ", neverallowFile).
+		Input(m4NeverallowFile)
 
 	// Step 2. Generate a M4 processed contexts file
 	builtCtx := m.buildGeneralContexts(ctx, inputs)
@@ -490,11 +485,17 @@ func (m *selinuxContextsModule) buildSeappContexts(ctx android.ModuleContext, in
 		Input(builtCtx).
 		Input(neverallowFile)
 
-	if m.shouldCheckCoredomain(ctx) {
-		checkCmd.Flag("-c") // check coredomain for vendor contexts
-	}
+	// checkseapp reports all violations of the domain rules at once.
+	checkCmd.FlagForEachArg("-x ", m.forbiddenDomainAttributes(ctx)).
+		FlagForEachArg("-r ", m.seappProperties.Domain_rules.Required_attributes).
+		FlagForEachArg("-e ", m.seappProperties.Domain_rules.Exempt_domains)
 
 	rule.Build("seapp_contexts", "Building seapp_contexts: "+m.Name())
+
+	android.SetProvider(ctx, seappContextsProviderKey, seappContextsInfo{
+		Partition:     seappPartition(m),
+		SeappContexts: ret,
+	})
 	return ret
 }
 
@@ -506,6 +507,7 @@ func hwServiceFactory() android.Module {
 
 func propertyFactory() android.Module {
 	m := newModule()
+	m.AddProperties(&m.propertyProperties)
 	m.build = m.buildPropertyContexts
 	m.deps = m.propertyContextsDeps
 	return m
@@ -545,8 +547,19 @@ var _ android.OutputFileProducer = (*selinuxContextsModule)(nil)
 
 // Implements android.OutputFileProducer
 func (m *selinuxContextsModule) OutputFiles(tag string) (android.Paths, error) {
-	if tag == "" {
+	switch tag {
+	case "":
 		return []android.Path{m.outputPath}, nil
+	case ".bin":
+		if m.binOutputPath == nil {
+			return nil, fmt.Errorf("%q is only available when compile_binary is true", tag)
+		}
+		return []android.Path{m.binOutputPath}, nil
+	case ".lint":
+		if m.lintReportPath == nil {
+			return nil, fmt.Errorf("%q is only available for file_contexts", tag)
+		}
+		return []android.Path{m.lintReportPath}, nil
 	}
 	return nil, fmt.Errorf("unsupported module reference tag %q", tag)
 }
@@ -583,6 +596,7 @@ const (
 	ServiceContext
 	HwServiceContext
 	VndServiceContext
+	Keystore2KeyContext
 )
 
 // checkfc parses a context file and checks for syntax errors.
@@ -633,10 +647,23 @@ func vndServiceContextsTestFactory() android.Module {
 	return m
 }
 
+// keystore2_key_contexts_test tests given keystore2_key_contexts files with
+// keystore2_key_contexts_check. Namespaces must be unique across all given files, so every
+// partition's keystore2_key_contexts should be listed in a single test.
+func keystore2KeyContextsTestFactory() android.Module {
+	m := &contextsTestModule{context: Keystore2KeyContext}
+	m.AddProperties(&m.properties)
+	android.InitAndroidArchModule(m, android.DeviceSupported, android.MultilibCommon)
+	return m
+}
+
 func (m *contextsTestModule) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	tool := "checkfc"
-	if m.context == PropertyContext {
+	switch m.context {
+	case PropertyContext:
 		tool = "property_info_checker"
+	case Keystore2KeyContext:
+		tool = "keystore2_key_contexts_check"
 	}
 
 	if len(m.properties.Srcs) == 0 {
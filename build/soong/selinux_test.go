@@ -15,10 +15,14 @@
 package selinux
 
 import (
+	"fmt"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/google/blueprint/proptools"
+
 	"android/soong/android"
 )
 
@@ -94,3 +98,539 @@ func TestFlagCollector(t *testing.T) {
 		)
 	}
 }
+
+func TestSelectSrcs(t *testing.T) {
+	t.Parallel()
+
+	bp := `
+		se_flags {
+			name: "se_flags",
+			flags: ["RELEASE_FLAGS_BAR", "RELEASE_FLAGS_FOO1", "RELEASE_FLAGS_FOO2"],
+			export_to: ["se_flags_collector"],
+		}
+		se_flags_collector {
+			name: "se_flags_collector",
+		}
+		se_policy_conf {
+			name: "test.conf",
+			srcs: ["a.te"],
+			build_flags: ["se_flags_collector"],
+			select_srcs: {
+				address_sanitize: ["asan.te"],
+				hwaddress_sanitize: ["hwasan.te"],
+				native_coverage: ["coverage.te"],
+				without_dexpreopt: ["nodexpreopt.te"],
+				build_flags: [
+					"RELEASE_FLAGS_BAR:bar.te",
+					"RELEASE_FLAGS_FOO1:foo.te",
+					"RELEASE_FLAGS_FOO2:foo.te",
+					// true, but not declared by se_flags
+					"RELEASE_AVF_ENABLE_DEVICE_ASSIGNMENT:vfio.te",
+				],
+			},
+		}
+		service_contexts {
+			name: "test_service_contexts",
+			srcs: ["a_service_contexts"],
+			build_flags: ["se_flags_collector"],
+			select_srcs: {
+				address_sanitize: ["asan_service_contexts"],
+				hwaddress_sanitize: ["hwasan_service_contexts"],
+				native_coverage: ["coverage_service_contexts"],
+				without_dexpreopt: ["nodexpreopt_service_contexts"],
+				build_flags: ["RELEASE_FLAGS_BAR:bar_service_contexts"],
+			},
+		}
+		`
+	mockFs := android.MockFS{}
+	for _, name := range []string{"a", "asan", "hwasan", "coverage", "nodexpreopt", "bar", "foo", "vfio"} {
+		mockFs["system/sepolicy/"+name+".te"] = nil
+		mockFs["system/sepolicy/"+name+"_service_contexts"] = nil
+	}
+
+	testCases := []struct {
+		name     string
+		modify   func(variables android.FixtureProductVariables)
+		selected []string
+	}{
+		{
+			name:     "default",
+			modify:   func(variables android.FixtureProductVariables) {},
+			selected: []string{"a", "bar"},
+		},
+		{
+			name: "address_sanitize",
+			modify: func(variables android.FixtureProductVariables) {
+				variables.SanitizeDevice = []string{"address"}
+			},
+			selected: []string{"a", "asan", "bar"},
+		},
+		{
+			name: "hwaddress_sanitize",
+			modify: func(variables android.FixtureProductVariables) {
+				variables.SanitizeDevice = []string{"hwaddress"}
+			},
+			selected: []string{"a", "hwasan", "bar"},
+		},
+		{
+			name: "native_coverage",
+			modify: func(variables android.FixtureProductVariables) {
+				variables.ClangCoverage = proptools.BoolPtr(true)
+			},
+			selected: []string{"a", "coverage", "bar"},
+		},
+		{
+			name: "without_dexpreopt",
+			modify: func(variables android.FixtureProductVariables) {
+				variables.WithDexpreopt = false
+			},
+			selected: []string{"a", "nodexpreopt", "bar"},
+		},
+	}
+	all := []string{"a", "asan", "hwasan", "coverage", "nodexpreopt", "bar", "foo", "vfio"}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := android.GroupFixturePreparers(
+				prepareForTest,
+				android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+					ctx.RegisterModuleType("se_policy_conf", policyConfFactory)
+					ctx.RegisterModuleType("service_contexts", serviceFactory)
+				}),
+				android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+					variables.WithDexpreopt = true
+					tc.modify(variables)
+				}),
+				android.FixtureMergeMockFs(mockFs),
+				android.FixtureAddTextFile("system/sepolicy/Android.bp", bp),
+			).RunTest(t).TestContext
+
+			conf := ctx.ModuleForTests("test.conf", "android_common").Output("test.conf").RuleParams.Command
+			contexts := ctx.ModuleForTests("test_service_contexts", "android_common").
+				Output("test_service_contexts").RuleParams.Command
+			for _, name := range all {
+				selected := android.InList(name, tc.selected)
+				checkSelected(t, "test.conf", conf, "system/sepolicy/"+name+".te", selected)
+				if name != "foo" && name != "vfio" {
+					checkSelected(t, "test_service_contexts", contexts,
+						"system/sepolicy/"+name+"_service_contexts", selected)
+				}
+			}
+		})
+	}
+}
+
+func checkSelected(t *testing.T, module, command, src string, selected bool) {
+	t.Helper()
+	if strings.Contains(command, src) != selected {
+		t.Errorf("%s: expected %s to be selected: %t, but command was:\n%s", module, src, selected, command)
+	}
+}
+
+func checkContains(t *testing.T, module, command string, wants ...string) {
+	t.Helper()
+	for _, want := range wants {
+		if !strings.Contains(command, want) {
+			t.Errorf("%s: expected command to contain %q, but was:\n%s", module, want, command)
+		}
+	}
+}
+
+func checkNotContains(t *testing.T, module, command string, unwanted ...string) {
+	t.Helper()
+	for _, s := range unwanted {
+		if strings.Contains(command, s) {
+			t.Errorf("%s: expected command not to contain %q, but was:\n%s", module, s, command)
+		}
+	}
+}
+
+func TestCompatMappingCheck(t *testing.T) {
+	t.Parallel()
+
+	ctx := android.GroupFixturePreparers(
+		prepareForTest,
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterModuleType("se_compat_mapping_check", compatMappingCheckFactory)
+		}),
+		android.FixtureMergeMockFs(android.MockFS{
+			"system/sepolicy/plat_pub_versioned.cil": nil,
+			"system/sepolicy/34.0.cil":               nil,
+			"system/sepolicy/product_34.0.cil":       nil,
+			"system/sepolicy/plat_sepolicy.cil":      nil,
+			"system/sepolicy/product_sepolicy.cil":   nil,
+		}),
+		android.FixtureAddTextFile("system/sepolicy/Android.bp", `
+			se_compat_mapping_check {
+				name: "34.0_compat_mapping_check",
+				version: "34.0",
+				versioned_policy: "plat_pub_versioned.cil",
+				plat_mapping: "34.0.cil",
+				product_mapping: "product_34.0.cil",
+				srcs: ["plat_sepolicy.cil", "product_sepolicy.cil"],
+			}
+			`),
+	).RunTest(t).TestContext
+
+	cmd := ctx.ModuleForTests("34.0_compat_mapping_check", "android_common").
+		Output("34.0_compat_mapping_check.txt").RuleParams.Command
+	checkContains(t, "34.0_compat_mapping_check", cmd,
+		"compat_mapping_check -v 34.0 -p system/sepolicy/plat_pub_versioned.cil",
+		"-m plat:system/sepolicy/34.0.cil",
+		"-m product:system/sepolicy/product_34.0.cil",
+		"-c system/sepolicy/plat_sepolicy.cil -c system/sepolicy/product_sepolicy.cil -o ")
+	checkNotContains(t, "34.0_compat_mapping_check", cmd, "-m system_ext:")
+}
+
+func TestCilCompatMapChain(t *testing.T) {
+	t.Parallel()
+
+	mockFs := android.MockFS{
+		"system/sepolicy/202404.cil": nil,
+		"system/sepolicy/34.0.cil":   nil,
+		"system/sepolicy/33.0.cil":   nil,
+	}
+	ctx := android.GroupFixturePreparers(
+		prepareForTest,
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterModuleType("se_cil_compat_map", cilCompatMapFactory)
+		}),
+		android.FixtureMergeMockFs(mockFs),
+		android.FixtureAddTextFile("system/sepolicy/Android.bp", `
+			se_cil_compat_map {
+				name: "plat_33.0.cil",
+				chain: ["202404.cil", "34.0.cil", "33.0.cil"],
+				version: "33.0",
+			}
+			`),
+	).RunTest(t).TestContext
+
+	cmd := ctx.ModuleForTests("plat_33.0.cil", "android_common").Output("plat_33.0.cil").RuleParams.Command
+	checkContains(t, "plat_33.0.cil", cmd,
+		"combine_maps -c system/sepolicy/202404.cil -c system/sepolicy/34.0.cil -c system/sepolicy/33.0.cil -r ",
+		"plat_33.0.cil.report.txt -o ")
+
+	android.GroupFixturePreparers(
+		prepareForTest,
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterModuleType("se_cil_compat_map", cilCompatMapFactory)
+		}),
+		android.FixtureMergeMockFs(mockFs),
+		android.FixtureAddTextFile("system/sepolicy/Android.bp", `
+			se_cil_compat_map {
+				name: "plat_33.0.cil",
+				chain: ["34.0.cil", "33.0.cil"],
+				bottom_half: ["33.0.cil"],
+				version: "33.0",
+			}
+			`),
+	).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`can't be set along with top_half or bottom_half`)).RunTest(t)
+}
+
+// compatCilFilegroups returns filegroups standing in for the current policy and the mapping and
+// compat files of versions, along with their source files.
+func compatCilFilegroups(versions ...string) (string, android.MockFS) {
+	names := []string{"plat_sepolicy.cil", "system_ext_sepolicy.cil", "product_sepolicy.cil",
+		"reqd_policy_mask.cil"}
+	for _, ver := range versions {
+		names = append(names, "plat_"+ver+".cil", ver+".compat.cil", "system_ext_"+ver+".cil",
+			"system_ext_"+ver+".compat.cil", "product_"+ver+".cil")
+	}
+	var bp strings.Builder
+	mockFs := android.MockFS{}
+	for _, name := range names {
+		fmt.Fprintf(&bp, "filegroup {\n\tname: %q,\n\tsrcs: [%q],\n}\n", name, "cil/"+name)
+		mockFs["system/sepolicy/cil/"+name] = nil
+	}
+	return bp.String(), mockFs
+}
+
+func TestVendorCompatTest(t *testing.T) {
+	t.Parallel()
+
+	filegroups, mockFs := compatCilFilegroups("34.0")
+	for _, name := range []string{"vendor_sepolicy.cil", "plat_pub_versioned.cil", "plat_sepolicy_vers.txt"} {
+		mockFs["system/sepolicy/prebuilt/"+name] = nil
+		mockFs["system/sepolicy/target_files/VENDOR/etc/selinux/"+name] = nil
+	}
+	mockFs["system/sepolicy/target_files/ODM/etc/selinux/odm_sepolicy.cil"] = nil
+	bp := filegroups + `
+		se_vendor_compat_test {
+			name: "vendor_compat_files",
+			version: "34.0",
+			vendor_sepolicy: "prebuilt/vendor_sepolicy.cil",
+			plat_pub_versioned: "prebuilt/plat_pub_versioned.cil",
+			plat_sepolicy_vers: "prebuilt/plat_sepolicy_vers.txt",
+		}
+		se_vendor_compat_test {
+			name: "vendor_compat_target_files",
+			version: "34.0",
+			target_files_dir: "target_files",
+		}
+		`
+	ctx := android.GroupFixturePreparers(
+		prepareForTest,
+		android.PrepareForTestWithFilegroup,
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterModuleType("se_compat_mapping_check", compatMappingCheckFactory)
+			ctx.RegisterModuleType("se_vendor_compat_test", vendorCompatTestFactory)
+		}),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.PlatformSepolicyCompatVersions = []string{"34.0"}
+		}),
+		android.FixtureMergeMockFs(mockFs),
+		android.FixtureAddTextFile("system/sepolicy/Android.bp", bp),
+	).RunTest(t).TestContext
+
+	for _, tc := range []struct {
+		module string
+		dir    string
+		odm    bool
+	}{
+		{module: "vendor_compat_files", dir: "system/sepolicy/prebuilt/"},
+		{module: "vendor_compat_target_files", dir: "system/sepolicy/target_files/VENDOR/etc/selinux/", odm: true},
+	} {
+		cmd := ctx.ModuleForTests(tc.module, "android_common").Output("timestamp").RuleParams.Command
+		checkContains(t, tc.module, cmd,
+			`if [ "$(cat `+tc.dir+`plat_sepolicy_vers.txt )" != 34.0 ]; then`,
+			"plat_sepolicy_vers.txt of the prebuilt vendor policy is",
+			"system/sepolicy/cil/plat_34.0.cil",
+			"system/sepolicy/cil/34.0.compat.cil",
+			tc.dir+"plat_pub_versioned.cil",
+			tc.dir+"vendor_sepolicy.cil",
+			" -N ")
+		odm := "system/sepolicy/target_files/ODM/etc/selinux/odm_sepolicy.cil"
+		if tc.odm {
+			checkContains(t, tc.module, cmd, odm)
+		} else {
+			checkNotContains(t, tc.module, cmd, odm)
+		}
+
+		check := ctx.ModuleForTests(tc.module+"_mapping_check", "android_common").
+			Output(tc.module + "_mapping_check.txt").RuleParams.Command
+		checkContains(t, tc.module+"_mapping_check", check, "-p "+tc.dir+"plat_pub_versioned.cil")
+	}
+
+	android.GroupFixturePreparers(
+		prepareForTest,
+		android.PrepareForTestWithFilegroup,
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterModuleType("se_compat_mapping_check", compatMappingCheckFactory)
+			ctx.RegisterModuleType("se_vendor_compat_test", vendorCompatTestFactory)
+		}),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.PlatformSepolicyCompatVersions = []string{"34.0"}
+		}),
+		android.FixtureMergeMockFs(mockFs),
+		android.FixtureAddTextFile("system/sepolicy/Android.bp", filegroups+`
+			se_vendor_compat_test {
+				name: "vendor_compat_test",
+				version: "34.0",
+				vendor_sepolicy: "prebuilt/vendor_sepolicy.cil",
+				target_files_dir: "target_files",
+			}
+			`),
+	).ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+		`can't be set along with prebuilt vendor policy files`)).RunTest(t)
+}
+
+func TestFreezeSnapshot(t *testing.T) {
+	t.Parallel()
+
+	ctx := android.GroupFixturePreparers(
+		prepareForTest,
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterModuleType("se_freeze_snapshot", freezeSnapshotFactory)
+		}),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.PlatformSepolicyVersion = proptools.StringPtr("202504")
+		}),
+		android.FixtureMergeMockFs(android.MockFS{
+			"system/sepolicy/public/a.te":        nil,
+			"system/sepolicy/private/b.te":       nil,
+			"system/sepolicy/mapping/202504.cil": nil,
+		}),
+		android.FixtureAddTextFile("system/sepolicy/Android.bp", `
+			se_freeze_snapshot {
+				name: "sepolicy_freeze_snapshot",
+				plat_mapping_file: "mapping/202504.cil",
+			}
+			`),
+	).RunTest(t).TestContext
+
+	m := ctx.ModuleForTests("sepolicy_freeze_snapshot", "android_common")
+	templates := m.Output("Android.bp").RuleParams.Command
+	checkContains(t, "sepolicy_freeze_snapshot", templates,
+		"compat_templates -v 202504 --prebuilt-api-bp ",
+		"compat_templates -v 202504 --compat ",
+		"plat/202504.compat.cil --ignore ",
+		"plat/202504.ignore.cil")
+
+	zip := m.Output("202504_sepolicy_snapshot.zip").RuleParams.Command
+	checkContains(t, "sepolicy_freeze_snapshot", zip,
+		"-P system/sepolicy/prebuilts/api/202504/public -C system/sepolicy/public -D system/sepolicy/public",
+		"-P system/sepolicy/prebuilts/api/202504/private -C system/sepolicy/private -D system/sepolicy/private",
+		"-P system/sepolicy/private/compat/202504 -C system/sepolicy/mapping -f system/sepolicy/mapping/202504.cil",
+		"-P system/sepolicy/prebuilts/api/202504 -C ")
+	// Neither system_ext nor product policy is set.
+	checkNotContains(t, "sepolicy_freeze_snapshot", templates, "system_ext/", "product/")
+}
+
+func TestCompatTest(t *testing.T) {
+	t.Parallel()
+
+	filegroups, mockFs := compatCilFilegroups("33.0", "34.0")
+	mockFs["system/sepolicy/reqd_mask/a.te"] = nil
+	mockFs["system/sepolicy/prebuilts/api/33.0/public/a.te"] = nil
+	mockFs["system/sepolicy/prebuilts/api/34.0/public/a.te"] = nil
+	bp := filegroups + `
+		se_build_files {
+			name: "se_build_files",
+			srcs: ["*.te"],
+		}
+		se_compat_test {
+			name: "sepolicy_compat_test",
+			min_version: "34.0",
+		}
+		`
+
+	testCases := []struct {
+		name    string
+		env     map[string]string
+		tested  []string
+		skipped []string
+	}{
+		{
+			name:    "min_version",
+			tested:  []string{"34.0"},
+			skipped: []string{"33.0"},
+		},
+		{
+			name:   "min_version_from_env",
+			env:    map[string]string{"SEPOLICY_COMPAT_TEST_MIN_VERSION": "33.0"},
+			tested: []string{"33.0", "34.0"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := android.GroupFixturePreparers(
+				prepareForTest,
+				android.PrepareForTestWithFilegroup,
+				android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+					ctx.RegisterModuleType("se_build_files", buildFilesFactory)
+					ctx.RegisterModuleType("se_policy_conf", policyConfFactory)
+					ctx.RegisterModuleType("se_policy_cil", policyCilFactory)
+					ctx.RegisterModuleType("se_policy_binary", policyBinaryFactory)
+					ctx.RegisterModuleType("se_versioned_policy", versionedPolicyFactory)
+					ctx.RegisterModuleType("se_compat_mapping_check", compatMappingCheckFactory)
+					ctx.RegisterParallelSingletonModuleType("se_compat_test", compatTestFactory)
+				}),
+				android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+					variables.PlatformSepolicyVersion = proptools.StringPtr("202404")
+					variables.PlatformSepolicyCompatVersions = []string{"33.0", "34.0"}
+				}),
+				android.FixtureMergeEnv(tc.env),
+				android.FixtureMergeMockFs(mockFs),
+				android.FixtureAddTextFile("system/sepolicy/Android.bp", bp),
+			).RunTest(t).TestContext
+
+			summary := ctx.ModuleForTests("sepolicy_compat_test", "").
+				Output("compat_test_summary.txt").RuleParams.Command
+			for _, ver := range tc.tested {
+				checkContains(t, "sepolicy_compat_test", summary,
+					`echo "`+ver+`: $(head -n 1`, ver+"_compat_test_result.txt")
+			}
+			for _, ver := range tc.skipped {
+				checkContains(t, "sepolicy_compat_test", summary,
+					`echo "`+ver+`: skipped, below min_version 34.0";`)
+				checkNotContains(t, "sepolicy_compat_test", summary, ver+"_compat_test_result.txt")
+			}
+			checkContains(t, "sepolicy_compat_test", summary, "if grep -q ': failed$'")
+
+			// Every version has its own result, and its own check, even if it's skipped.
+			for _, ver := range []string{"33.0", "34.0"} {
+				result := ctx.ModuleForTests(ver+"_compat_test", "android_common").
+					Output(ver + "_compat_test_result.txt").RuleParams.Command
+				checkContains(t, ver+"_compat_test", result,
+					"system/sepolicy/cil/plat_"+ver+".cil",
+					"system/sepolicy/cil/"+ver+".compat.cil",
+					"system/sepolicy/cil/system_ext_"+ver+".compat.cil",
+					" -N ",
+					"2>&1; then echo passed >",
+					"else { echo failed; cat ")
+				check := ctx.ModuleForTests("sepolicy_compat_test", "").Output(ver + "/timestamp").RuleParams.Command
+				checkContains(t, "sepolicy_compat_test", check,
+					ver+"_compat_test_result.txt )\" != passed ]")
+			}
+		})
+	}
+}
+
+func TestImageVariantInstallPaths(t *testing.T) {
+	t.Parallel()
+
+	ctx := android.GroupFixturePreparers(
+		prepareForTest,
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterModuleType("service_contexts", serviceFactory)
+			ctx.RegisterModuleType("se_policy_cil", policyCilFactory)
+			ctx.RegisterModuleType("se_policy_binary", policyBinaryFactory)
+		}),
+		android.FixtureMergeMockFs(android.MockFS{
+			"system/sepolicy/test_service_contexts": nil,
+			"system/sepolicy/test.conf":             nil,
+			"system/sepolicy/test.cil":              nil,
+		}),
+		android.FixtureAddTextFile("system/sepolicy/Android.bp", `
+			service_contexts {
+				name: "test_service_contexts",
+				srcs: ["test_service_contexts"],
+				recovery_available: true,
+				ramdisk_available: true,
+				vendor_ramdisk_available: true,
+				debug_ramdisk_available: true,
+			}
+			se_policy_cil {
+				name: "test_sepolicy.cil",
+				src: "test.conf",
+				recovery_available: true,
+				ramdisk_available: true,
+				vendor_ramdisk_available: true,
+				debug_ramdisk_available: true,
+			}
+			se_policy_binary {
+				name: "test_sepolicy",
+				srcs: ["test.cil"],
+				recovery_available: true,
+				ramdisk_available: true,
+				vendor_ramdisk_available: true,
+				debug_ramdisk_available: true,
+			}
+			`),
+	).RunTest(t).TestContext
+
+	productOut := "out/soong/target/product/test_device/"
+	variants := []struct {
+		variant string
+		dir     string
+	}{
+		{"android_common", "system/etc/selinux"},
+		{"android_recovery_common", "recovery/root"},
+		{"android_ramdisk_common", "ramdisk"},
+		{"android_vendor_ramdisk_common", "vendor_ramdisk"},
+		{"android_debug_ramdisk_common", "debug_ramdisk"},
+	}
+	for _, v := range variants {
+		contexts := ctx.ModuleForTests("test_service_contexts", v.variant).Module().(*selinuxContextsModule)
+		android.AssertPathRelativeToTopEquals(t, "test_service_contexts install path in "+v.variant,
+			productOut+v.dir, contexts.installPath)
+		cil := ctx.ModuleForTests("test_sepolicy.cil", v.variant).Module().(*policyCil)
+		android.AssertPathRelativeToTopEquals(t, "test_sepolicy.cil install path in "+v.variant,
+			productOut+v.dir, cil.installPath)
+		binary := ctx.ModuleForTests("test_sepolicy", v.variant).Module().(*policyBinary)
+		android.AssertPathRelativeToTopEquals(t, "test_sepolicy install path in "+v.variant,
+			productOut+v.dir, binary.installPath)
+	}
+}
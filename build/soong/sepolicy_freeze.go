@@ -15,24 +15,107 @@
 package selinux
 
 import (
+	"fmt"
 	"sort"
+	"strings"
 
 	"android/soong/android"
+
+	"github.com/google/blueprint"
 )
 
 var currentCilTag = dependencyTag{name: "current_cil"}
 var prebuiltCilTag = dependencyTag{name: "prebuilt_cil"}
+var freezeDirsTag = dependencyTag{name: "freeze_dirs"}
 
 func init() {
 	ctx := android.InitRegistrationContext
 	ctx.RegisterParallelSingletonModuleType("se_freeze_test", freezeTestFactory)
+	ctx.RegisterModuleType("se_freeze_test_dirs", freezeDirsFactory)
+}
+
+type freezeDirsProperties struct {
+	// Directory of the current policy, e.g. "public".
+	Current *string
+
+	// Directory of the frozen prebuilt policy, e.g. "prebuilts/api/202404/public".
+	Prebuilt *string
+
+	// Glob patterns of files to skip in both directories, e.g. "bug_map". A pattern matches either
+	// the path relative to the directory or the file name.
+	Exclude []string
+}
+
+type freezeDirsModule struct {
+	android.ModuleBase
+	properties freezeDirsProperties
+}
+
+type freezeDirsInfo struct {
+	Current  android.Path
+	Prebuilt android.Path
+	Exclude  []string
+	Srcs     android.Paths
+}
+
+var freezeDirsProviderKey = blueprint.NewProvider[freezeDirsInfo]()
+
+// se_freeze_test_dirs declares a pair of policy directories, relative to the module's directory,
+// which se_freeze_test compares once system/sepolicy freezes. This lets system_ext and product
+// policy owners freeze their public policy without setting Makefile variables. For example:
+//
+//	se_freeze_test_dirs {
+//		name: "my_system_ext_freeze_test_dirs",
+//		current: "system_ext/public",
+//		prebuilt: "prebuilts/api/202404/system_ext/public",
+//		exclude: ["bug_map"],
+//	}
+func freezeDirsFactory() android.Module {
+	module := &freezeDirsModule{}
+	module.AddProperties(&module.properties)
+	android.InitAndroidModule(module)
+	return module
+}
+
+func (f *freezeDirsModule) DepsMutator(ctx android.BottomUpMutatorContext) {
+	// dep se_freeze_test -> se_freeze_test_dirs
+	ctx.AddReverseDependency(ctx.Module(), freezeDirsTag, "se_freeze_test")
+}
+
+func (f *freezeDirsModule) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	if f.properties.Current == nil || f.properties.Prebuilt == nil {
+		ctx.ModuleErrorf("current and prebuilt must be set")
+		return
+	}
+	for _, pattern := range f.properties.Exclude {
+		if strings.ContainsAny(pattern, ":,") {
+			ctx.PropertyErrorf("exclude", "%q can't contain ':' or ','", pattern)
+		}
+	}
+
+	var srcs, excludes []string
+	for _, dir := range []string{*f.properties.Current, *f.properties.Prebuilt} {
+		srcs = append(srcs, dir+"/**/*")
+		for _, pattern := range f.properties.Exclude {
+			excludes = append(excludes, dir+"/**/"+pattern)
+		}
+	}
+
+	android.SetProvider(ctx, freezeDirsProviderKey, freezeDirsInfo{
+		Current:  android.PathForModuleSrc(ctx, *f.properties.Current),
+		Prebuilt: android.PathForModuleSrc(ctx, *f.properties.Prebuilt),
+		Exclude:  f.properties.Exclude,
+		Srcs:     android.PathsForModuleSrcExcludes(ctx, srcs, excludes),
+	})
 }
 
 // se_freeze_test compares the plat sepolicy with the prebuilt sepolicy.  Additional directories can
-// be specified via Makefile variables: SEPOLICY_FREEZE_TEST_EXTRA_DIRS and
-// SEPOLICY_FREEZE_TEST_EXTRA_PREBUILT_DIRS.
+// be specified via se_freeze_test_dirs modules, or via Makefile variables:
+// SEPOLICY_FREEZE_TEST_EXTRA_DIRS and SEPOLICY_FREEZE_TEST_EXTRA_PREBUILT_DIRS. Its output file is
+// a report of all differences found.
 func freezeTestFactory() android.SingletonModule {
 	f := &freezeTestModule{}
+	f.AddProperties(&f.properties)
 	android.InitAndroidModule(f)
 	android.AddLoadHook(f, func(ctx android.LoadHookContext) {
 		f.loadHook(ctx)
@@ -40,9 +123,17 @@ func freezeTestFactory() android.SingletonModule {
 	return f
 }
 
+type freezeTestProperties struct {
+	// File listing the differences from the prebuilt policy which are allowed after the freeze,
+	// each with a bug ID and a reason. Exceptions which match no difference are reported.
+	Exceptions *string `android:"path"`
+}
+
 type freezeTestModule struct {
 	android.SingletonModuleBase
+	properties          freezeTestProperties
 	freezeTestTimestamp android.ModuleOutPath
+	freezeTestReport    android.ModuleOutPath
 }
 
 func (f *freezeTestModule) shouldRunTest(ctx android.EarlyModuleContext) bool {
@@ -115,10 +206,12 @@ func (f *freezeTestModule) outputFileOfDep(ctx android.ModuleContext, depTag dep
 
 func (f *freezeTestModule) GenerateAndroidBuildActions(ctx android.ModuleContext) {
 	f.freezeTestTimestamp = android.PathForModuleOut(ctx, "freeze_test")
+	f.freezeTestReport = android.PathForModuleOut(ctx, "freeze_report.txt")
 
 	if !f.shouldRunTest(ctx) {
 		// we still build a rule to prevent possible regression
 		android.WriteFileRule(ctx, f.freezeTestTimestamp, ";; no freeze tests needed before system/sepolicy freezes")
+		android.WriteFileRule(ctx, f.freezeTestReport, "no freeze tests needed before system/sepolicy freezes")
 		return
 	}
 
@@ -129,13 +222,10 @@ func (f *freezeTestModule) GenerateAndroidBuildActions(ctx android.ModuleContext
 		return
 	}
 
-	rule := android.NewRuleBuilder(pctx, ctx)
-	rule.Command().BuiltTool("sepolicy_freeze_test").
-		FlagWithInput("-c ", currentCil).
-		FlagWithInput("-p ", prebuiltCil)
-
 	// Freeze test 2: compare extra directories
-	// We don't know the exact structure of extra directories, so just directly compare them
+	// We don't know the exact structure of extra directories, so every file in them is compared,
+	// except for bug_map or the files se_freeze_test_dirs modules exclude. Declarations are also
+	// compared to explain the differences.
 	extraDirs := ctx.DeviceConfig().SepolicyFreezeTestExtraDirs()
 	extraPrebuiltDirs := ctx.DeviceConfig().SepolicyFreezeTestExtraPrebuiltDirs()
 
@@ -150,22 +240,48 @@ func (f *freezeTestModule) GenerateAndroidBuildActions(ctx android.ModuleContext
 	}
 	sort.Strings(implicits)
 
+	var dirPairs []string
 	for idx, _ := range extraDirs {
-		rule.Command().Text("diff").
-			Flag("-r").
-			Flag("-q").
-			FlagWithArg("-x ", "bug_map"). // exclude
-			Text(extraDirs[idx]).
-			Text(extraPrebuiltDirs[idx])
+		dirPairs = append(dirPairs, extraDirs[idx]+":"+extraPrebuiltDirs[idx]+":bug_map")
+	}
+
+	var srcs android.Paths
+	ctx.VisitDirectDepsWithTag(freezeDirsTag, func(m android.Module) {
+		if dep, ok := android.OtherModuleProvider(ctx, m, freezeDirsProviderKey); ok {
+			dirPairs = append(dirPairs, dep.Current.String()+":"+dep.Prebuilt.String()+":"+
+				strings.Join(dep.Exclude, ","))
+			srcs = append(srcs, dep.Srcs...)
+		} else {
+			ctx.ModuleErrorf("unknown dependency %q", ctx.OtherModuleName(m))
+		}
+	})
+
+	rule := android.NewRuleBuilder(pctx, ctx)
+	cmd := rule.Command().BuiltTool("sepolicy_freeze_test").
+		FlagWithInput("-c ", currentCil).
+		FlagWithInput("-p ", prebuiltCil).
+		FlagForEachArg("-d ", dirPairs).
+		FlagWithOutput("-o ", f.freezeTestReport)
+	if f.properties.Exceptions != nil {
+		cmd.FlagWithInput("-e ", android.PathForModuleSrc(ctx, *f.properties.Exceptions))
 	}
 
 	rule.Command().Text("touch").
 		Output(f.freezeTestTimestamp).
-		Implicits(android.PathsForSource(ctx, implicits))
+		Implicits(android.PathsForSource(ctx, implicits)).
+		Implicits(srcs)
 
 	rule.Build("sepolicy_freeze_test", "sepolicy_freeze_test")
 }
 
+func (f *freezeTestModule) OutputFiles(tag string) (android.Paths, error) {
+	switch tag {
+	case "":
+		return android.Paths{f.freezeTestReport}, nil
+	}
+	return nil, fmt.Errorf("unsupported module reference tag %q", tag)
+}
+
 func (f *freezeTestModule) AndroidMkEntries() []android.AndroidMkEntries {
 	return []android.AndroidMkEntries{android.AndroidMkEntries{
 		Class: "FAKE",
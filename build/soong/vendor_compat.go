@@ -0,0 +1,241 @@
+// Copyright 2024 The Android Open Source Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selinux
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+)
+
+var vendorCompatTestSrcTag = dependencyTag{name: "vendor_compat_test_src"}
+var vendorCompatTestPrecheckTag = dependencyTag{name: "vendor_compat_test_precheck"}
+
+func init() {
+	android.RegisterModuleType("se_vendor_compat_test", vendorCompatTestFactory)
+}
+
+type vendorCompatTestProperties struct {
+	// Sepolicy version of the vendor policy, e.g. "33.0". It must be one of
+	// PLATFORM_SEPOLICY_COMPAT_VERSIONS, and match plat_sepolicy_vers.
+	Version *string
+
+	// Prebuilt vendor policy files. Either these or target_files_dir must be set.
+	Vendor_sepolicy    *string `android:"path"`
+	Plat_pub_versioned *string `android:"path"`
+	Plat_sepolicy_vers *string `android:"path"`
+	Odm_sepolicy       *string `android:"path"`
+
+	// Directory of an extracted target-files package, relative to this module. The vendor policy
+	// files are taken from VENDOR/etc/selinux, and odm_sepolicy.cil from ODM/etc/selinux if it
+	// exists.
+	Target_files_dir *string
+}
+
+type vendorCompatTest struct {
+	android.ModuleBase
+	properties vendorCompatTestProperties
+
+	timestamp android.ModuleOutPath
+}
+
+// se_vendor_compat_test compiles the current platform policy against a prebuilt vendor policy of an
+// older release, with the mapping and compat files of its version. It checks that a platform-only
+// OTA still boots with the old vendor image.
+func vendorCompatTestFactory() android.Module {
+	m := &vendorCompatTest{}
+	m.AddProperties(&m.properties)
+	android.InitAndroidArchModule(m, android.DeviceSupported, android.MultilibCommon)
+	android.AddLoadHook(m, func(ctx android.LoadHookContext) {
+		m.loadHook(ctx)
+	})
+	return m
+}
+
+// version returns the version of the vendor policy, or "" if it isn't a compat version.
+func (m *vendorCompatTest) version(ctx android.EarlyModuleContext) string {
+	ver := proptools.String(m.properties.Version)
+	for _, v := range ctx.DeviceConfig().PlatformSepolicyCompatVersions() {
+		if v == ver {
+			return ver
+		}
+	}
+	return ""
+}
+
+// prebuiltFile returns the path of a prebuilt vendor policy file, relative to this module, or nil.
+func (m *vendorCompatTest) prebuiltFile(prop *string, partition, name string) *string {
+	if dir := proptools.String(m.properties.Target_files_dir); dir != "" {
+		return proptools.StringPtr(filepath.Join(dir, partition, "etc", "selinux", name))
+	}
+	return prop
+}
+
+func (m *vendorCompatTest) loadHook(ctx android.LoadHookContext) {
+	ver := m.version(ctx)
+	if ver == "" {
+		ctx.PropertyErrorf("version", "%q isn't one of PLATFORM_SEPOLICY_COMPAT_VERSIONS %q",
+			proptools.String(m.properties.Version), ctx.DeviceConfig().PlatformSepolicyCompatVersions())
+		return
+	}
+
+	ctx.CreateModule(compatMappingCheckFactory, &nameProperties{
+		Name: proptools.StringPtr(m.Name() + "_mapping_check"),
+	}, &compatMappingCheckProperties{
+		Version:            proptools.StringPtr(ver),
+		Versioned_policy:   m.prebuiltFile(m.properties.Plat_pub_versioned, "VENDOR", "plat_pub_versioned.cil"),
+		Plat_mapping:       proptools.StringPtr(fmt.Sprintf(":plat_%s.cil", ver)),
+		System_ext_mapping: proptools.StringPtr(fmt.Sprintf(":system_ext_%s.cil", ver)),
+		Product_mapping:    proptools.StringPtr(fmt.Sprintf(":product_%s.cil", ver)),
+		Srcs: []string{
+			":plat_sepolicy.cil",
+			":system_ext_sepolicy.cil",
+			":product_sepolicy.cil",
+		},
+	})
+}
+
+func (m *vendorCompatTest) DepsMutator(ctx android.BottomUpMutatorContext) {
+	ver := m.version(ctx)
+	if ver == "" {
+		return
+	}
+	ctx.AddDependency(ctx.Module(), vendorCompatTestSrcTag,
+		"plat_sepolicy.cil",
+		"system_ext_sepolicy.cil",
+		"product_sepolicy.cil",
+		fmt.Sprintf("plat_%s.cil", ver),
+		fmt.Sprintf("%s.compat.cil", ver),
+		fmt.Sprintf("system_ext_%s.cil", ver),
+		fmt.Sprintf("system_ext_%s.compat.cil", ver),
+		fmt.Sprintf("product_%s.cil", ver),
+	)
+	ctx.AddDependency(ctx.Module(), vendorCompatTestPrecheckTag, m.Name()+"_mapping_check")
+}
+
+func (m *vendorCompatTest) outputFilesOfDeps(ctx android.ModuleContext, depTag dependencyTag) android.Paths {
+	var result android.Paths
+	ctx.VisitDirectDepsWithTag(depTag, func(dep android.Module) {
+		if producer, ok := dep.(android.OutputFileProducer); ok {
+			if outputs, err := producer.OutputFiles(""); err == nil && len(outputs) == 1 {
+				result = append(result, outputs[0])
+				return
+			}
+		}
+		ctx.ModuleErrorf("module %q must produce exactly one output file", ctx.OtherModuleName(dep))
+	})
+	return result
+}
+
+func (m *vendorCompatTest) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	m.timestamp = android.PathForModuleOut(ctx, "timestamp")
+
+	ver := m.version(ctx)
+	if ver == "" {
+		return
+	}
+
+	if m.properties.Target_files_dir != nil {
+		for _, prop := range []*string{m.properties.Vendor_sepolicy, m.properties.Plat_pub_versioned,
+			m.properties.Plat_sepolicy_vers, m.properties.Odm_sepolicy} {
+			if prop != nil {
+				ctx.PropertyErrorf("target_files_dir", "can't be set along with prebuilt vendor policy files")
+				return
+			}
+		}
+	} else if m.properties.Vendor_sepolicy == nil || m.properties.Plat_pub_versioned == nil ||
+		m.properties.Plat_sepolicy_vers == nil {
+		ctx.ModuleErrorf("either vendor_sepolicy, plat_pub_versioned and plat_sepolicy_vers, or " +
+			"target_files_dir must be set")
+		return
+	}
+
+	vendorSepolicy := android.PathForModuleSrc(ctx,
+		*m.prebuiltFile(m.properties.Vendor_sepolicy, "VENDOR", "vendor_sepolicy.cil"))
+	platPubVersioned := android.PathForModuleSrc(ctx,
+		*m.prebuiltFile(m.properties.Plat_pub_versioned, "VENDOR", "plat_pub_versioned.cil"))
+	platSepolicyVers := android.PathForModuleSrc(ctx,
+		*m.prebuiltFile(m.properties.Plat_sepolicy_vers, "VENDOR", "plat_sepolicy_vers.txt"))
+
+	srcs := m.outputFilesOfDeps(ctx, vendorCompatTestSrcTag)
+	srcs = append(srcs, platPubVersioned, vendorSepolicy)
+	if dir := proptools.String(m.properties.Target_files_dir); dir != "" {
+		// Devices without an odm partition don't have odm_sepolicy.cil.
+		if odm := android.ExistentPathForSource(ctx, ctx.ModuleDir(), dir, "ODM", "etc", "selinux",
+			"odm_sepolicy.cil"); odm.Valid() {
+			srcs = append(srcs, odm.Path())
+		}
+	} else if m.properties.Odm_sepolicy != nil {
+		srcs = append(srcs, android.PathForModuleSrc(ctx, *m.properties.Odm_sepolicy))
+	}
+	prechecks := m.outputFilesOfDeps(ctx, vendorCompatTestPrecheckTag)
+	if ctx.Failed() {
+		return
+	}
+
+	rule := android.NewRuleBuilder(pctx, ctx)
+	// The mapping files are chosen by the version property, so make sure it's the vendor's version.
+	rule.Command().Text("if [ \"$(cat").Input(platSepolicyVers).
+		Text(")\" != " + proptools.ShellEscape(ver) + " ]; then").
+		Text("echo \"plat_sepolicy_vers.txt of the prebuilt vendor policy is $(cat").
+		Input(platSepolicyVers).
+		Text("), but version is " + ver + "\" >&2; exit 1; fi")
+
+	bin := android.PathForModuleOut(ctx, "policy")
+	rule.Command().BuiltTool("secilc").
+		Flag("-m").                 // Multiple decls
+		FlagWithArg("-M ", "true"). // Enable MLS
+		Flag("-G").                 // expand and remove auto generated attributes
+		FlagWithArg("-c ", strconv.Itoa(PolicyVers)).
+		Flag("-N"). // old vendor policy isn't expected to pass the current neverallows
+		Inputs(srcs).
+		FlagWithOutput("-o ", bin).
+		FlagWithArg("-f ", os.DevNull).
+		Implicits(prechecks)
+	rule.Temporary(bin)
+	rule.Command().Text("touch").Output(m.timestamp)
+	rule.DeleteTemporaryFiles()
+	rule.Build("vendor_compat_test", "Compiling current platform policy against "+ver+" vendor policy")
+}
+
+func (m *vendorCompatTest) OutputFiles(tag string) (android.Paths, error) {
+	switch tag {
+	case "":
+		return android.Paths{m.timestamp}, nil
+	}
+	return nil, fmt.Errorf("unsupported module reference tag %q", tag)
+}
+
+var _ android.OutputFileProducer = (*vendorCompatTest)(nil)
+
+func (m *vendorCompatTest) AndroidMkEntries() []android.AndroidMkEntries {
+	return []android.AndroidMkEntries{android.AndroidMkEntries{
+		Class: "FAKE",
+		// OutputFile is needed, even though BUILD_PHONY_PACKAGE doesn't use it.
+		// Without OutputFile this module won't be exported to Makefile.
+		OutputFile: android.OptionalPathForPath(m.timestamp),
+		Include:    "$(BUILD_PHONY_PACKAGE)",
+		ExtraEntries: []android.AndroidMkExtraEntriesFunc{
+			func(ctx android.AndroidMkExtraEntriesContext, entries *android.AndroidMkEntries) {
+				entries.SetString("LOCAL_ADDITIONAL_DEPENDENCIES", m.timestamp.String())
+			},
+		},
+	}}
+}
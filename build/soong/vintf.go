@@ -0,0 +1,97 @@
+// Copyright 2024 The Android Open Source Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package selinux
+
+import (
+	"android/soong/android"
+)
+
+func init() {
+	android.RegisterModuleType("se_vintf_contexts_test", vintfContextsTestFactory)
+}
+
+type vintfContextsTestProperties struct {
+	// VINTF manifest fragments of the device, e.g. [":manifest_android.hardware.foo-service.xml"].
+	Manifests []string `android:"path"`
+
+	// Files listing names of stable AIDL interfaces, one per line, e.g. android.hardware.foo.IFoo.
+	// service_contexts entries of these interfaces are checked like those named "package.IFoo/instance".
+	Aidl_interfaces []string `android:"path"`
+
+	// service_contexts of all partitions, e.g. [":plat_service_contexts", ":vendor_service_contexts"].
+	Service_contexts []string `android:"path"`
+
+	// hwservice_contexts of all partitions.
+	Hwservice_contexts []string `android:"path"`
+
+	// Files of service_contexts or hwservice_contexts whose HAL entries must all be declared by
+	// manifests. Each must also be listed in service_contexts or hwservice_contexts.
+	Stale_check []string `android:"path"`
+}
+
+type vintfContextsTestModule struct {
+	android.ModuleBase
+
+	properties    vintfContextsTestProperties
+	testTimestamp android.ModuleOutPath
+}
+
+// se_vintf_contexts_test checks that every AIDL instance and HIDL interface declared by VINTF
+// manifest fragments is labeled by service_contexts or hwservice_contexts, and that the stale-checked
+// contexts files don't label HALs which no manifest declares. The manifest fragments are device
+// specific, so there's no instance in this project; devices opt in by defining one listing theirs.
+func vintfContextsTestFactory() android.Module {
+	m := &vintfContextsTestModule{}
+	m.AddProperties(&m.properties)
+	android.InitAndroidArchModule(m, android.DeviceSupported, android.MultilibCommon)
+	return m
+}
+
+func (m *vintfContextsTestModule) GenerateAndroidBuildActions(ctx android.ModuleContext) {
+	if len(m.properties.Manifests) == 0 {
+		ctx.PropertyErrorf("manifests", "can't be empty")
+		return
+	}
+	if len(m.properties.Service_contexts) == 0 && len(m.properties.Hwservice_contexts) == 0 {
+		ctx.ModuleErrorf("service_contexts or hwservice_contexts must be set")
+		return
+	}
+
+	m.testTimestamp = android.PathForModuleOut(ctx, "timestamp")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	rule.Command().BuiltTool("vintf_contexts_check").
+		FlagForEachInput("-m ", android.PathsForModuleSrc(ctx, m.properties.Manifests)).
+		FlagForEachInput("--aidl-interfaces ", android.PathsForModuleSrc(ctx, m.properties.Aidl_interfaces)).
+		FlagForEachInput("-s ", android.PathsForModuleSrc(ctx, m.properties.Service_contexts)).
+		FlagForEachInput("-w ", android.PathsForModuleSrc(ctx, m.properties.Hwservice_contexts)).
+		FlagForEachInput("--stale-check ", android.PathsForModuleSrc(ctx, m.properties.Stale_check))
+	rule.Command().Text("touch").Output(m.testTimestamp)
+	rule.Build("vintf_contexts_test", "checking VINTF manifests against contexts: "+ctx.ModuleName())
+}
+
+func (m *vintfContextsTestModule) AndroidMkEntries() []android.AndroidMkEntries {
+	return []android.AndroidMkEntries{android.AndroidMkEntries{
+		Class: "FAKE",
+		// OutputFile is needed, even though BUILD_PHONY_PACKAGE doesn't use it.
+		// Without OutputFile this module won't be exported to Makefile.
+		OutputFile: android.OptionalPathForPath(m.testTimestamp),
+		Include:    "$(BUILD_PHONY_PACKAGE)",
+		ExtraEntries: []android.AndroidMkExtraEntriesFunc{
+			func(ctx android.AndroidMkExtraEntriesContext, entries *android.AndroidMkEntries) {
+				entries.SetString("LOCAL_ADDITIONAL_DEPENDENCIES", m.testTimestamp.String())
+			},
+		},
+	}}
+}
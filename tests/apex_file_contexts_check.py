@@ -0,0 +1,220 @@
+#!/usr/bin/env python3
+#
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""A tool to validate file_contexts of APEXes at build time.
+
+Usage:
+    $ apex_file_contexts_check check -p precompiled_sepolicy -f com.foo-file_contexts
+    $ apex_file_contexts_check collisions -f com.foo=foo_fc -f com.bar=bar_fc
+
+'check' validates a single APEX: every spec must be relative to the APEX root,
+and every label must be a file type (an exec type for *_exec labels) which
+exists in the policy.
+
+'collisions' validates all APEXes together: a label which isn't shared on
+purpose (e.g. system_file) may be used by only one APEX.
+"""
+
+import argparse
+import os
+import pkgutil
+import sys
+import tempfile
+from dataclasses import dataclass
+from typing import List
+
+import policy
+
+
+SHARED_LIB_EXTENSION = '.dylib' if sys.platform == 'darwin' else '.so'
+LIBSEPOLWRAP = "libsepolwrap" + SHARED_LIB_EXTENSION
+
+# Specs in APEX file_contexts are matched against paths relative to the APEX root
+# (e.g. /bin/foo for /apex/com.android.foo/bin/foo), so they must not start with
+# any of these device roots.
+DEVICE_ROOTS = [
+    "/apex/",
+    "/data/",
+    "/dev/",
+    "/odm/",
+    "/proc/",
+    "/product/",
+    "/sys/",
+    "/system/",
+    "/system_ext/",
+    "/vendor/",
+]
+
+# Labels which every APEX may use.
+DEFAULT_SHARED_LABELS = {
+    "system_file",
+    "system_lib_file",
+}
+
+
+@dataclass
+class Entry:
+    """A single file_contexts entry of an APEX."""
+    path: str
+    lineno: int
+    spec: str
+    label: str
+
+    def location(self):
+        return f"{self.path}:{self.lineno}"
+
+
+def parse_file(path) -> (List[Entry], List[str]):
+    """Parses a file_contexts file. Returns (entries, errors)."""
+    entries = []
+    errors = []
+    with open(path, 'r', encoding='utf-8') as f:
+        for lineno, line in enumerate(f, 1):
+            line = line.strip()
+            if not line or line.startswith('#'):
+                continue
+            split = line.split()
+            if len(split) not in (2, 3) or len(split[-1].split(':')) != 4:
+                errors.append(f"{path}:{lineno}: invalid file_contexts entry \"{line}\"")
+                continue
+            entries.append(Entry(path, lineno, split[0], split[-1].split(':')[2]))
+    return entries, errors
+
+
+def check_spec(entry: Entry) -> List[str]:
+    """Returns an error if the spec isn't relative to the APEX root."""
+    spec = entry.spec
+    if not spec.startswith('/') and not spec.startswith('(/'):
+        return [f"{entry.location()}: {spec} must start with '/' (the APEX root)"]
+    for root in DEVICE_ROOTS:
+        if spec.startswith(root):
+            return [f"{entry.location()}: {spec} must be relative to the APEX root, "
+                    f"not to the device root ({root})"]
+    return []
+
+
+def check_label(entry: Entry, all_types, file_types, exec_types) -> List[str]:
+    """Returns an error if the label doesn't exist or lacks required attributes."""
+    label = entry.label
+    if label not in all_types:
+        return [f"{entry.location()}: {label} is not declared in the policy"]
+    if label not in file_types:
+        return [f"{entry.location()}: {label} must be associated with the \"file_type\" attribute"]
+    if label.endswith("_exec") and label not in exec_types:
+        return [f"{entry.location()}: {label} must be associated with the \"exec_type\" attribute"]
+    return []
+
+
+def check_apex(pol, path) -> List[str]:
+    """Validates file_contexts of a single APEX against the policy."""
+    entries, errors = parse_file(path)
+    all_types = pol.GetAllTypes(False)
+    file_types = pol.QueryTypeAttribute("file_type", True)
+    exec_types = pol.QueryTypeAttribute("exec_type", True)
+    for entry in entries:
+        errors.extend(check_spec(entry))
+        errors.extend(check_label(entry, all_types, file_types, exec_types))
+    return errors
+
+
+def find_collisions(apexes, shared_labels, allowed_pairs) -> List[str]:
+    """Returns an error for each label which is used by more than one APEX.
+
+    apexes is a dict from an APEX name to its entries. allowed_pairs is a set of
+    (apex, apex) tuples which may share labels with each other.
+    """
+    owners = {}
+    for apex in sorted(apexes):
+        for entry in apexes[apex]:
+            if entry.label in shared_labels:
+                continue
+            owners.setdefault(entry.label, {}).setdefault(apex, entry)
+
+    errors = []
+    for label in sorted(owners):
+        users = sorted(owners[label])
+        first = users[0]
+        for other in users[1:]:
+            if (first, other) in allowed_pairs or (other, first) in allowed_pairs:
+                continue
+            errors.append(f"{owners[label][other].location()}: {label} is already used by "
+                          f"{first} ({owners[label][first].location()})")
+    return errors
+
+
+def do_check(args, work_dir):
+    lib_path = os.path.join(work_dir, LIBSEPOLWRAP)
+    with open(lib_path, 'wb') as f:
+        blob = pkgutil.get_data('apex_file_contexts_check', LIBSEPOLWRAP)
+        if not blob:
+            sys.exit("Error: libsepolwrap does not exist. Is this binary corrupted?\n")
+        f.write(blob)
+    pol = policy.Policy(args.policy, None, lib_path)
+    return check_apex(pol, args.file_contexts)
+
+
+def do_collisions(args):
+    apexes = {}
+    errors = []
+    for arg in args.file_contexts:
+        apex, sep, path = arg.partition('=')
+        if not sep:
+            sys.exit(f"Error: {arg} must have the form <apex>=<file_contexts>")
+        entries, parse_errors = parse_file(path)
+        apexes[apex] = entries
+        errors.extend(parse_errors)
+
+    allowed_pairs = set()
+    for arg in args.allow_sharing or []:
+        first, sep, second = arg.partition(':')
+        if not sep:
+            sys.exit(f"Error: {arg} must have the form <apex>:<apex>")
+        allowed_pairs.add((first, second))
+
+    shared_labels = DEFAULT_SHARED_LABELS | set(args.shared_label or [])
+    errors.extend(find_collisions(apexes, shared_labels, allowed_pairs))
+    return errors
+
+
+def do_main(work_dir):
+    parser = argparse.ArgumentParser()
+    subparsers = parser.add_subparsers(dest='command', required=True)
+
+    check_parser = subparsers.add_parser('check', help='validates file_contexts of an APEX')
+    check_parser.add_argument('-p', '--policy', required=True, help='precompiled sepolicy')
+    check_parser.add_argument('-f', '--file_contexts', required=True)
+
+    collisions_parser = subparsers.add_parser('collisions',
+                                              help='finds labels used by more than one APEX')
+    collisions_parser.add_argument('-f', '--file_contexts', action='append', required=True,
+                                   help='<apex>=<file_contexts>')
+    collisions_parser.add_argument('--allow-sharing', action='append',
+                                   help='<apex>:<apex> pair which may share labels')
+    collisions_parser.add_argument('--shared-label', action='append',
+                                   help='label which any APEX may use')
+
+    args = parser.parse_args()
+    if args.command == 'check':
+        errors = do_check(args, work_dir)
+    else:
+        errors = do_collisions(args)
+
+    if errors:
+        sys.exit('\n'.join(errors))
+
+
+if __name__ == '__main__':
+    with tempfile.TemporaryDirectory() as temp_dir:
+        do_main(temp_dir)
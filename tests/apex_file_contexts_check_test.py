@@ -0,0 +1,141 @@
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Tests for apex_file_contexts_check"""
+
+import argparse
+import os
+import shutil
+import tempfile
+import unittest
+
+import apex_file_contexts_check
+
+
+class FakePolicy:
+    """Policy with a fixed set of types and attributes."""
+
+    def __init__(self, attributes):
+        self.attributes = attributes
+
+    def GetAllTypes(self, _):
+        types = set()
+        for members in self.attributes.values():
+            types |= members
+        return types
+
+    def QueryTypeAttribute(self, attribute, _):
+        return self.attributes.get(attribute, set())
+
+
+POLICY = FakePolicy({
+    'file_type': {'foo_file', 'foo_exec', 'bar_data_file', 'system_file'},
+    'exec_type': {'foo_exec'},
+    'domain': {'foo', 'foo_noexec_exec'},
+})
+
+
+# pylint: disable=missing-docstring
+class ApexFileContextsCheckTest(unittest.TestCase):
+
+    def setUp(self):
+        self.temp_dir = tempfile.mkdtemp()
+
+    def tearDown(self):
+        shutil.rmtree(self.temp_dir)
+
+    def write(self, name, content):
+        path = os.path.join(self.temp_dir, name)
+        with open(path, 'w', encoding='utf-8') as f:
+            f.write(content)
+        return path
+
+    def test_relative_paths(self):
+        path = self.write('fc', '\n'.join([
+            '(/.*)?                u:object_r:system_file:s0',
+            '/bin/foo              u:object_r:foo_exec:s0',
+            '/system/bin/foo       u:object_r:foo_exec:s0',
+            '/apex/com.foo/etc(/.*)?   u:object_r:foo_file:s0',
+            'bin/foo               u:object_r:foo_exec:s0',
+        ]))
+        errors = apex_file_contexts_check.check_apex(POLICY, path)
+        self.assertEqual(len(errors), 3)
+        self.assertIn(':3: /system/bin/foo must be relative to the APEX root', errors[0])
+        self.assertIn('(/system/)', errors[0])
+        self.assertIn(':4: /apex/com.foo/etc(/.*)? must be relative', errors[1])
+        self.assertIn(":5: bin/foo must start with '/'", errors[2])
+
+    def test_labels(self):
+        path = self.write('fc', '\n'.join([
+            '/etc/foo              u:object_r:foo_file:s0',
+            '/etc/undeclared       u:object_r:undeclared_file:s0',
+            '/etc/domain           u:object_r:foo:s0',
+            '/bin/noexec           u:object_r:foo_noexec_exec:s0',
+            '/bin/data             u:object_r:bar_data_file:s0',
+            '/bin/invalid          foo_file',
+        ]))
+        errors = apex_file_contexts_check.check_apex(POLICY, path)
+        self.assertEqual(len(errors), 4)
+        self.assertIn('invalid file_contexts entry "/bin/invalid          foo_file"', errors[0])
+        self.assertIn(':2: undeclared_file is not declared in the policy', errors[1])
+        self.assertIn(':3: foo must be associated with the "file_type" attribute', errors[2])
+        self.assertIn(':4: foo_noexec_exec must be associated with the "file_type" attribute',
+                      errors[3])
+
+    def test_exec_type(self):
+        policy = FakePolicy({'file_type': {'bar_exec'}})
+        path = self.write('fc', '/bin/bar u:object_r:bar_exec:s0\n')
+        errors = apex_file_contexts_check.check_apex(policy, path)
+        self.assertEqual(len(errors), 1)
+        self.assertIn('bar_exec must be associated with the "exec_type" attribute', errors[0])
+
+    def collisions(self, allow_sharing=None, shared_label=None):
+        foo = self.write('foo_fc', '\n'.join([
+            '(/.*)?      u:object_r:system_file:s0',
+            '/bin/foo    u:object_r:foo_exec:s0',
+            '/etc/data   u:object_r:data_file:s0',
+        ]))
+        foo_debug = self.write('foo_debug_fc', '/bin/foo u:object_r:foo_exec:s0\n')
+        bar = self.write('bar_fc', '\n'.join([
+            '(/.*)?      u:object_r:system_file:s0',
+            '/etc/data   u:object_r:data_file:s0',
+        ]))
+        args = argparse.Namespace(
+            file_contexts=[f"com.foo={foo}", f"com.foo.debug={foo_debug}", f"com.bar={bar}"],
+            allow_sharing=allow_sharing, shared_label=shared_label)
+        return apex_file_contexts_check.do_collisions(args)
+
+    def test_collisions(self):
+        errors = self.collisions()
+        self.assertEqual(len(errors), 2)
+        self.assertIn('data_file is already used by com.bar', errors[0])
+        self.assertIn('foo_fc:3', errors[0])
+        self.assertIn('foo_exec is already used by com.foo', errors[1])
+        self.assertIn('foo_debug_fc:1', errors[1])
+
+    def test_allow_sharing(self):
+        errors = self.collisions(allow_sharing=['com.foo.debug:com.foo'])
+        self.assertEqual(len(errors), 1)
+        self.assertIn('data_file is already used by com.bar', errors[0])
+
+        errors = self.collisions(allow_sharing=['com.foo.debug:com.foo'],
+                                 shared_label=['data_file'])
+        self.assertEqual(errors, [])
+
+    def test_allow_sharing_invalid(self):
+        with self.assertRaises(SystemExit):
+            self.collisions(allow_sharing=['com.foo.debug'])
+
+
+if __name__ == '__main__':
+    unittest.main(verbosity=2)
@@ -0,0 +1,236 @@
+#!/usr/bin/env python3
+#
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Checks properties of build.prop files against property_contexts.
+
+Usage:
+    $ build_prop_check -p precompiled_sepolicy \\
+        -c plat_property_contexts -c vendor_property_contexts \\
+        --prop system=system/build.prop --prop vendor=vendor/build.prop
+
+For each property set by a prop file:
+  - a property_contexts entry other than the "*" catch-all must match it.
+  - its partition must own it. init loads prop files of vendor partitions as
+    vendor_init, so vendor_init must be allowed to set it. Platform prop files
+    must not set vendor properties.
+  - its value must match the type declared in property_contexts.
+"""
+
+import argparse
+import os
+import pkgutil
+import re
+import sys
+import tempfile
+from dataclasses import dataclass, field
+from typing import List, Optional
+
+import policy
+
+SHARED_LIB_EXTENSION = '.dylib' if sys.platform == 'darwin' else '.so'
+LIBSEPOLWRAP = "libsepolwrap" + SHARED_LIB_EXTENSION
+
+PLATFORM_PARTITIONS = {'system', 'system_ext', 'product'}
+VENDOR_PARTITIONS = {'vendor', 'odm', 'vendor_dlkm', 'odm_dlkm'}
+
+SIZE_PATTERN = re.compile(r'\d+[gkm]?')
+
+
+@dataclass
+class PropertyContext:
+    """A single property_contexts entry."""
+    location: str
+    name: str
+    context: str
+    exact: bool = False
+    prop_type: str = 'string'
+    enum_values: List[str] = field(default_factory=list)
+
+    def label(self):
+        split = self.context.split(':')
+        return split[2] if len(split) >= 3 else self.context
+
+
+@dataclass
+class Property:
+    """A property set by a prop file."""
+    location: str
+    partition: str
+    name: str
+    value: str
+
+
+def parse_property_contexts(path) -> (List[PropertyContext], List[str]):
+    """Returns (entries, errors) of a property_contexts file."""
+    entries = []
+    errors = []
+    with open(path, 'r', encoding='utf-8') as f:
+        for lineno, line in enumerate(f, 1):
+            line = line.strip()
+            if not line or line.startswith('#'):
+                continue
+            location = f"{path}:{lineno}"
+            split = line.split()
+            if len(split) < 2:
+                errors.append(f"{location}: invalid property_contexts entry \"{line}\"")
+                continue
+            entry = PropertyContext(location, split[0], split[1])
+            if len(split) >= 3:
+                if split[2] not in ('exact', 'prefix'):
+                    errors.append(f"{location}: unknown match type {split[2]}")
+                    continue
+                entry.exact = split[2] == 'exact'
+            if len(split) >= 4:
+                entry.prop_type = split[3]
+                entry.enum_values = split[4:]
+            entries.append(entry)
+    return entries, errors
+
+
+def parse_prop_file(path, partition) -> List[Property]:
+    """Returns properties set by a build.prop or default.prop file."""
+    props = []
+    with open(path, 'r', encoding='utf-8') as f:
+        for lineno, line in enumerate(f, 1):
+            line = line.strip()
+            if not line or line.startswith('#') or line.startswith('import '):
+                continue
+            name, sep, value = line.partition('=')
+            if not sep:
+                continue
+            # "name?=value" only sets the property if it isn't set yet.
+            name = name.strip().rstrip('?')
+            props.append(Property(f"{path}:{lineno}", partition, name, value.strip()))
+    return props
+
+
+def lookup(entries: List[PropertyContext], name) -> Optional[PropertyContext]:
+    """Returns the entry which matches name, like property_info_area does.
+
+    An exact match wins, and otherwise the longest matching prefix, where "*"
+    is an empty prefix. Among equal entries, the last one wins.
+    """
+    exact = [e for e in entries if e.exact and e.name == name]
+    if exact:
+        return exact[-1]
+
+    best = None
+    best_len = -1
+    for entry in entries:
+        if entry.exact:
+            continue
+        prefix = '' if entry.name == '*' else entry.name
+        if name.startswith(prefix) and len(prefix) >= best_len:
+            best = entry
+            best_len = len(prefix)
+    return best
+
+
+def check_type(entry: PropertyContext, value) -> bool:
+    """Returns whether value is valid for the type of entry."""
+    if value == '':
+        return True
+    prop_type = entry.prop_type
+    try:
+        if prop_type == 'string':
+            return True
+        if prop_type == 'bool':
+            return value in ('true', 'false', '1', '0')
+        if prop_type == 'int':
+            int(value, 10)
+            return True
+        if prop_type == 'uint':
+            return int(value, 10) >= 0 and not value.startswith('-')
+        if prop_type == 'double':
+            float(value)
+            return True
+        if prop_type == 'size':
+            return SIZE_PATTERN.fullmatch(value) is not None
+        if prop_type == 'enum':
+            return value in entry.enum_values
+    except ValueError:
+        return False
+    return False
+
+
+def check_property(prop: Property, entries, vendor_settable, vendor_types) -> List[str]:
+    """Returns errors for a property set by a prop file of the given partition."""
+    prefix = f"{prop.location}: {prop.name}"
+    entry = lookup(entries, prop.name)
+    if entry is None or entry.name == '*':
+        return [f"{prefix} has no property_contexts entry"]
+
+    errors = []
+    label = entry.label()
+    if prop.partition in VENDOR_PARTITIONS and label not in vendor_settable:
+        errors.append(f"{prefix} is labeled {label} ({entry.location}), which {prop.partition} "
+                      f"doesn't own: vendor_init isn't allowed to set it")
+    elif prop.partition in PLATFORM_PARTITIONS and label in vendor_types:
+        errors.append(f"{prefix} is labeled {label} ({entry.location}), which is owned by "
+                      f"vendor, not {prop.partition}")
+
+    if not check_type(entry, prop.value):
+        expected = entry.prop_type
+        if expected == 'enum':
+            expected += ' ' + ' '.join(entry.enum_values)
+        errors.append(f"{prefix}={prop.value} doesn't match type {expected} ({entry.location})")
+    return errors
+
+
+def load_policy(policy_path, work_dir):
+    lib_path = os.path.join(work_dir, LIBSEPOLWRAP)
+    with open(lib_path, 'wb') as f:
+        blob = pkgutil.get_data('build_prop_check', LIBSEPOLWRAP)
+        if not blob:
+            sys.exit("Error: libsepolwrap does not exist. Is this binary corrupted?\n")
+        f.write(blob)
+    return policy.Policy(policy_path, None, lib_path)
+
+
+def do_main(work_dir):
+    parser = argparse.ArgumentParser()
+    parser.add_argument('-p', '--policy', required=True, help='precompiled sepolicy')
+    parser.add_argument('-c', '--property_contexts', action='append', required=True,
+                        help='property_contexts of all partitions')
+    parser.add_argument('--prop', action='append', required=True,
+                        help='<partition>=<prop file>')
+    args = parser.parse_args()
+
+    entries = []
+    errors = []
+    for path in args.property_contexts:
+        file_entries, file_errors = parse_property_contexts(path)
+        entries.extend(file_entries)
+        errors.extend(file_errors)
+
+    pol = load_policy(args.policy, work_dir)
+    vendor_settable = set(rule.tctx for rule in pol.QueryExpandedTERule(
+        scontext={'vendor_init'}, tclass={'property_service'}, perms={'set'}))
+    vendor_types = pol.QueryTypeAttribute('vendor_property_type', True)
+
+    for arg in args.prop:
+        partition, sep, path = arg.partition('=')
+        if not sep or partition not in PLATFORM_PARTITIONS | VENDOR_PARTITIONS:
+            sys.exit(f"Error: {arg} must have the form <partition>=<prop file>")
+        for prop in parse_prop_file(path, partition):
+            errors.extend(check_property(prop, entries, vendor_settable, vendor_types))
+
+    if errors:
+        sys.exit('Properties which don\'t match property_contexts:\n' + '\n'.join(errors))
+
+
+if __name__ == '__main__':
+    with tempfile.TemporaryDirectory() as temp_dir:
+        do_main(temp_dir)
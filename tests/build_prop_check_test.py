@@ -0,0 +1,114 @@
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Tests for build_prop_check"""
+
+import os
+import shutil
+import tempfile
+import unittest
+
+import build_prop_check as check
+
+
+PROPERTY_CONTEXTS = """
+*                       u:object_r:default_prop:s0
+ro.                     u:object_r:build_prop:s0
+ro.vendor.              u:object_r:vendor_default_prop:s0
+ro.vendor.foo.enabled   u:object_r:vendor_foo_prop:s0 exact bool
+ro.vendor.foo.mode      u:object_r:vendor_foo_prop:s0 exact enum fast slow
+ro.config.count         u:object_r:config_prop:s0 exact int
+ro.config.size          u:object_r:config_prop:s0 exact size
+"""
+
+VENDOR_BUILD_PROP = """
+# comment
+import /vendor/etc/other.prop
+ro.vendor.foo.enabled=true
+ro.vendor.foo.mode=medium
+ro.vendor.bar?=1
+ro.build.foo=bar
+unknown.prop=1
+ro.config.count=12a
+ro.config.size=64m
+"""
+
+
+# pylint: disable=missing-docstring
+class BuildPropCheckTest(unittest.TestCase):
+
+    def setUp(self):
+        self.temp_dir = tempfile.mkdtemp()
+        self.entries, errors = check.parse_property_contexts(
+            self.write('property_contexts', PROPERTY_CONTEXTS))
+        self.assertEqual(errors, [])
+
+    def tearDown(self):
+        shutil.rmtree(self.temp_dir)
+
+    def write(self, name, content):
+        path = os.path.join(self.temp_dir, name)
+        with open(path, 'w', encoding='utf-8') as f:
+            f.write(content)
+        return path
+
+    def test_lookup(self):
+        self.assertEqual(check.lookup(self.entries, 'ro.vendor.foo.enabled').label(),
+                         'vendor_foo_prop')
+        self.assertEqual(check.lookup(self.entries, 'ro.vendor.foo.enabled2').label(),
+                         'vendor_default_prop')
+        self.assertEqual(check.lookup(self.entries, 'ro.foo').label(), 'build_prop')
+        self.assertEqual(check.lookup(self.entries, 'foo').label(), 'default_prop')
+
+    def test_check_type(self):
+        def valid(prop_type, value, enum_values=()):
+            entry = check.PropertyContext('', 'p', 'u:object_r:p:s0', True, prop_type,
+                                          list(enum_values))
+            return check.check_type(entry, value)
+        self.assertTrue(valid('bool', '1'))
+        self.assertFalse(valid('bool', 'yes'))
+        self.assertTrue(valid('int', '-3'))
+        self.assertFalse(valid('uint', '-3'))
+        self.assertTrue(valid('double', '0.5'))
+        self.assertTrue(valid('size', '512k'))
+        self.assertFalse(valid('size', '512kb'))
+        self.assertTrue(valid('enum', 'b', ['a', 'b']))
+        self.assertFalse(valid('enum', 'c', ['a', 'b']))
+        self.assertTrue(valid('int', ''))
+
+    def test_vendor_build_prop(self):
+        props = check.parse_prop_file(self.write('build.prop', VENDOR_BUILD_PROP), 'vendor')
+        self.assertEqual([p.name for p in props], [
+            'ro.vendor.foo.enabled', 'ro.vendor.foo.mode', 'ro.vendor.bar', 'ro.build.foo',
+            'unknown.prop', 'ro.config.count', 'ro.config.size'])
+
+        vendor_settable = {'vendor_foo_prop', 'vendor_default_prop', 'config_prop'}
+        errors = {p.name: check.check_property(p, self.entries, vendor_settable, set())
+                  for p in props}
+        self.assertEqual(errors['ro.vendor.foo.enabled'], [])
+        self.assertIn("doesn't match type enum fast slow", errors['ro.vendor.foo.mode'][0])
+        self.assertEqual(errors['ro.vendor.bar'], [])
+        self.assertIn("vendor doesn't own", errors['ro.build.foo'][0])
+        self.assertIn('has no property_contexts entry', errors['unknown.prop'][0])
+        self.assertIn("doesn't match type int", errors['ro.config.count'][0])
+        self.assertEqual(errors['ro.config.size'], [])
+
+    def test_platform_sets_vendor_property(self):
+        prop = check.Property('build.prop:1', 'system', 'ro.vendor.foo.enabled', 'true')
+        errors = check.check_property(prop, self.entries, set(), {'vendor_foo_prop'})
+        self.assertEqual(len(errors), 1)
+        self.assertIn('owned by vendor, not system', errors[0])
+
+
+if __name__ == '__main__':
+    unittest.main(verbosity=2)
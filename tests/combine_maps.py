@@ -26,12 +26,18 @@ types in the top map.
 More generally, we can correctly construct x->z from x->y' and y"->z as long as
 y">y'.
 
-This file contains the implementation of combining two mapping files.
+This file contains the implementation of combining two mapping files, or an
+ordered chain of them. A chain x->y, y->z, z->w is combined step by step into
+x->w. Each step is validated against the previous one, and the types of x which
+vanish or fan out along the chain are reported.
 """
 import argparse
 import re
+import sys
 from mini_parser import MiniCilParser
 
+VERSION_SUFFIX = re.compile(r"_(\d+_\d+|\d{6})$")
+
 def Combine(top, bottom):
     bottom.types.update(top.types)
     bottom.typeattributes.update(top.typeattributes)
@@ -41,9 +47,8 @@ def Combine(top, bottom):
         if len(top_type_set) == 1:
             continue
 
-        m = re.match(r"(\w+)_\d+_\d+", top_ta)
-        # Typeattributes in V.v.cil have _V_v suffix, but not in V.v.ignore.cil
-        bottom_type = m.group(1) if m else top_ta
+        # Typeattributes in V.v.cil have _V_v or _YYYYMM suffix, but not in V.v.ignore.cil
+        bottom_type = VERSION_SUFFIX.sub("", top_ta)
 
         # If type doesn't exist in bottom map, no need to maintain mappings to
         # that type.
@@ -55,19 +60,111 @@ def Combine(top, bottom):
 
     return bottom
 
+def StepVersion(step):
+    """Returns the version a mapping step maps to, e.g. "33_0", or None for ignore maps."""
+    counts = {}
+    for ta in step.typeattributesets:
+        m = VERSION_SUFFIX.search(ta)
+        if m:
+            counts[m.group(1)] = counts.get(m.group(1), 0) + 1
+    if not counts:
+        return None
+    return max(counts, key=counts.get)
+
+
+def VersionValue(version):
+    return float(version.replace("_", "."))
+
+
+def CombineChain(steps, names):
+    """Combines mapping steps, newest first, into one mapping.
+
+    Returns (result, errors, report). Errors are steps which can't follow the
+    step before them. The report lists types of the first step which vanish or
+    fan out along the chain.
+    """
+    errors = []
+    report = []
+    versions = [StepVersion(step) for step in steps]
+    tracked = set(steps[0].rTypeattributesets)
+    vanished = set()
+    fanned_out = set()
+
+    result = steps[0]
+    for i in range(1, len(steps)):
+        prev_version, version = versions[i - 1], versions[i]
+        if (prev_version is None) != (version is None):
+            errors.append("%s: can't combine a mapping file with an ignore map file %s" %
+                          (names[i], names[i - 1]))
+            continue
+        if version is not None:
+            if VersionValue(version) >= VersionValue(prev_version):
+                errors.append("%s: maps to version %s, which isn't older than %s of %s" %
+                              (names[i], version, prev_version, names[i - 1]))
+                continue
+            # Types of the previous step's version are the inputs of this step.
+            outputs = {VERSION_SUFFIX.sub("", ta) for ta in result.typeattributesets
+                       if ta.endswith("_" + prev_version)}
+            inputs = set(steps[i].rTypeattributesets)
+            if inputs and not inputs & outputs:
+                errors.append("%s: maps none of the %s types %s produces" %
+                              (names[i], prev_version, names[i - 1]))
+                continue
+            for t in sorted(inputs - outputs - steps[i].types - tracked):
+                report.append("%s: input %s isn't a %s type of %s" %
+                              (names[i], t, prev_version, names[i - 1]))
+
+        result = Combine(result, steps[i])
+        # Combine() doesn't update rTypeattributesets.
+        targets_of = {}
+        for ta, types in result.typeattributesets.items():
+            for t in types:
+                targets_of.setdefault(t, set()).add(ta)
+        result.rTypeattributesets = targets_of
+
+        step_name = "%s (%s)" % (names[i], version.replace("_", ".")) if version else names[i]
+        for t in sorted(tracked - vanished):
+            targets = targets_of.get(t, set())
+            if not targets:
+                vanished.add(t)
+                report.append("%s: %s vanishes" % (step_name, t))
+            elif len(targets) > 1 and t not in fanned_out:
+                fanned_out.add(t)
+                report.append("%s: %s fans out to %s" % (step_name, t, " ".join(sorted(targets))))
+
+    return result, errors, report
+
+
 if __name__ == "__main__":
     parser = argparse.ArgumentParser()
     parser.add_argument("-t", "--top-map", dest="top_map",
-                        required=True, help="top map file")
+                        help="top map file")
     parser.add_argument("-b", "--bottom-map", dest="bottom_map",
-                        required=True, help="bottom map file")
+                        help="bottom map file")
+    parser.add_argument("-c", "--chain", dest="chain", action="append",
+                        help="map file of a step of the chain, newest first")
+    parser.add_argument("-r", "--report", dest="report",
+                        help="report of types vanishing or fanning out along the chain")
     parser.add_argument("-o", "--output-file", dest="output_file",
                         required=True, help="output map file")
     args = parser.parse_args()
 
-    top_map_cil = MiniCilParser(args.top_map)
-    bottom_map_cil = MiniCilParser(args.bottom_map)
-    result = Combine(top_map_cil, bottom_map_cil)
+    if args.chain:
+        if args.top_map or args.bottom_map:
+            sys.exit("Error: --chain can't be used with --top-map or --bottom-map")
+        result, errors, report = CombineChain(
+            [MiniCilParser(step) for step in args.chain], args.chain)
+        if errors:
+            sys.exit("\n".join(["Error: invalid chain of mapping files:"] + errors))
+        if args.report:
+            with open(args.report, "w") as output:
+                output.writelines(line + "\n" for line in report)
+    else:
+        if not args.top_map or not args.bottom_map:
+            sys.exit("Error: either --chain or both --top-map and --bottom-map are required")
+        top_map_cil = MiniCilParser(args.top_map)
+        bottom_map_cil = MiniCilParser(args.bottom_map)
+        result = Combine(top_map_cil, bottom_map_cil)
 
     with open(args.output_file, "w") as output:
         output.write(result.unparse())
@@ -0,0 +1,95 @@
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Tests for combine_maps"""
+
+import os
+import shutil
+import tempfile
+import unittest
+
+import combine_maps
+from mini_parser import MiniCilParser
+
+
+MAP_34 = """
+(typeattributeset foo_34_0 (foo))
+(typeattributeset bar_34_0 (bar new_bar))
+(typeattributeset baz_34_0 (baz))
+"""
+
+MAP_33 = """
+(type old)
+(typeattributeset foo_33_0 (foo))
+(typeattributeset bar_33_0 (bar))
+(typeattributeset bar2_33_0 (bar))
+(typeattributeset old_33_0 (old))
+"""
+
+MAP_32 = """
+(typeattributeset foo_32_0 (foo))
+"""
+
+
+# pylint: disable=missing-docstring
+class CombineMapsTest(unittest.TestCase):
+
+    def setUp(self):
+        self.temp_dir = tempfile.mkdtemp()
+
+    def tearDown(self):
+        shutil.rmtree(self.temp_dir)
+
+    def parse(self, name, content):
+        path = os.path.join(self.temp_dir, name)
+        with open(path, 'w', encoding='utf-8') as f:
+            f.write(content)
+        return MiniCilParser(path)
+
+    def test_chain(self):
+        steps = [self.parse('34.0.cil', MAP_34), self.parse('33.0.cil', MAP_33),
+                 self.parse('32.0.cil', MAP_32)]
+        result, errors, report = combine_maps.CombineChain(steps, ['34', '33', '32'])
+        self.assertEqual(errors, [])
+        self.assertEqual(result.typeattributesets, {'foo_32_0': {'foo'}})
+        self.assertEqual(report, [
+            '33 (33.0): bar fans out to bar2_33_0 bar_33_0',
+            '33 (33.0): baz vanishes',
+            '33 (33.0): new_bar fans out to bar2_33_0 bar_33_0',
+            '32 (32.0): bar vanishes',
+            '32 (32.0): new_bar vanishes',
+        ])
+
+    def test_chain_202404(self):
+        steps = [self.parse('202404.cil', '(typeattributeset foo_202404 (foo newfoo))\n'),
+                 self.parse('34.0.cil', '(typeattributeset foo_34_0 (foo))\n')]
+        result, errors, report = combine_maps.CombineChain(steps, ['202404', '34'])
+        self.assertEqual(errors, [])
+        self.assertEqual(result.typeattributesets, {'foo_34_0': {'foo', 'newfoo'}})
+        self.assertEqual(report, [])
+
+    def test_invalid_chain(self):
+        steps = [self.parse('33.0.cil', MAP_33), self.parse('34.0.cil', MAP_34)]
+        _, errors, _ = combine_maps.CombineChain(steps, ['33', '34'])
+        self.assertEqual(len(errors), 1)
+        self.assertIn("isn't older than 33_0", errors[0])
+
+        steps = [self.parse('34.0.cil', MAP_34), self.parse('32.0.cil', MAP_32.replace(
+            'foo', 'qux'))]
+        _, errors, _ = combine_maps.CombineChain(steps, ['34', '32'])
+        self.assertEqual(len(errors), 1)
+        self.assertIn('maps none of the 34_0 types', errors[0])
+
+
+if __name__ == '__main__':
+    unittest.main(verbosity=2)
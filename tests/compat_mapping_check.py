@@ -0,0 +1,126 @@
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Checks that compat mapping files cover a versioned public policy.
+
+Usage:
+    $ compat_mapping_check -v 34.0 -p plat_pub_versioned_34.0.cil \\
+        -m plat:plat_34.0.cil -m system_ext:system_ext_34.0.cil \\
+        -m product:product_34.0.cil \\
+        -c plat_sepolicy.cil -c system_ext_sepolicy.cil -c product_sepolicy.cil \\
+        -o 34.0_compat_mapping_check.txt
+
+secilc fails with little context when a public type is removed without a
+mapping. This finds such problems first, and names the version and partition:
+  - every versioned type of the versioned public policy must be mapped by one of
+    the mapping files;
+  - every type a mapping file maps to must be declared by the current policy, or
+    by the mapping file itself;
+  - every attribute the versioned public policy adds types to must be declared.
+"""
+
+import argparse
+import re
+import sys
+from typing import Dict, List, Set
+
+DECLARATION_PATTERN = re.compile(r'\((?:type|typeattribute)\s+([\w.-]+)\s*\)')
+TYPEATTRIBUTESET_PATTERN = re.compile(r'\(typeattributeset\s+([\w.-]+)\s+\(([^()]*)\)\s*\)')
+IDENTIFIER_PATTERN = re.compile(r'[\w.-]+')
+
+
+def read_cil(path):
+    """Returns the content of a CIL file without comments."""
+    with open(path, 'r', encoding='utf-8') as f:
+        return ''.join(line.split(';', 1)[0] + '\n' for line in f)
+
+
+def declarations(text) -> Set[str]:
+    return set(DECLARATION_PATTERN.findall(text))
+
+
+def typeattributesets(text) -> Dict[str, Set[str]]:
+    result = {}
+    for attribute, members in TYPEATTRIBUTESET_PATTERN.findall(text):
+        result.setdefault(attribute, set()).update(members.split())
+    return result
+
+
+def check(version, versioned_policy, mappings: Dict[str, str], current: List[str]):
+    """Returns errors of the mapping files of a version.
+
+    versioned_policy, the values of mappings and the items of current are CIL texts. The keys of
+    mappings are partition names.
+    """
+    suffix = '_' + version.replace('.', '_')
+    declared = set()
+    for text in current:
+        declared |= declarations(text)
+    mapping_declared = {p: declarations(text) for p, text in mappings.items()}
+    mapping_sets = {p: typeattributesets(text) for p, text in mappings.items()}
+    mapped = set()
+    for sets in mapping_sets.values():
+        mapped |= set(sets)
+
+    errors = []
+    versioned_declared = declarations(versioned_policy)
+    referenced = {t for t in IDENTIFIER_PATTERN.findall(versioned_policy) if t.endswith(suffix)}
+    partitions = ', '.join(mappings)
+    for versioned_type in sorted(referenced - mapped):
+        errors.append(f"{version}: {versioned_type} is used by the versioned public policy, but "
+                      f"{version}.cil of {partitions} doesn't map it")
+
+    for partition, sets in mapping_sets.items():
+        for versioned_type, members in sorted(sets.items()):
+            for member in sorted(members - declared - mapping_declared[partition]):
+                errors.append(f"{version}: {partition} {version}.cil maps {versioned_type} to "
+                              f"{member}, which isn't declared. If {member} was removed, declare "
+                              f"it in {version}.cil or map {versioned_type} to its replacement")
+
+    for attribute in sorted(typeattributesets(versioned_policy)):
+        if attribute.endswith(suffix) or attribute in declared or attribute in versioned_declared:
+            continue
+        errors.append(f"{version}: attribute {attribute} is used by the versioned public policy, "
+                      "but isn't declared")
+    return errors
+
+
+def do_main(argv):
+    parser = argparse.ArgumentParser()
+    parser.add_argument('-v', '--version', required=True, help='compat version')
+    parser.add_argument('-p', '--versioned-policy', required=True,
+                        help='versioned public policy, e.g. plat_pub_versioned.cil')
+    parser.add_argument('-m', '--mapping', action='append', default=[],
+                        help='<partition>:<mapping CIL file>')
+    parser.add_argument('-c', '--current', action='append', default=[],
+                        help='CIL file of the current policy')
+    parser.add_argument('-o', '--output', required=True, help='result of the check')
+    args = parser.parse_args(argv)
+
+    mappings = {}
+    for arg in args.mapping:
+        partition, sep, path = arg.partition(':')
+        if not sep:
+            sys.exit(f"Error: {arg} must have the form <partition>:<mapping CIL file>")
+        mappings[partition] = read_cil(path)
+
+    errors = check(args.version, read_cil(args.versioned_policy), mappings,
+                   [read_cil(path) for path in args.current])
+    with open(args.output, 'w', encoding='utf-8') as f:
+        f.writelines(e + '\n' for e in errors)
+    if errors:
+        sys.exit('\n'.join([f"Compat mapping files of {args.version} are incomplete:"] + errors))
+
+
+if __name__ == '__main__':
+    do_main(sys.argv[1:])
@@ -0,0 +1,71 @@
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Tests for compat_mapping_check"""
+
+import unittest
+
+import compat_mapping_check
+
+
+VERSIONED_POLICY = """
+(typeattribute foo_34_0)
+(typeattribute bar_34_0)
+(typeattribute baz_34_0)
+(typeattribute vendor_attr)
+(allow foo_34_0 bar_34_0 (file (read)))
+(typeattributeset domain (foo_34_0))
+(typeattributeset vendor_attr (baz_34_0))
+(typeattributeset removed_attr (baz_34_0))
+"""
+
+PLAT_MAPPING = """
+;; types removed from current policy
+(type racoon)
+(expandtypeattribute (foo_34_0) true)
+(typeattributeset foo_34_0 (foo racoon))
+(typeattributeset bar_34_0 (bar))
+"""
+
+SYSTEM_EXT_MAPPING = """
+(typeattributeset qux_34_0 (racoon))
+"""
+
+CURRENT = """
+(type foo)
+(typeattribute domain)
+"""
+
+
+# pylint: disable=missing-docstring
+class CompatMappingCheckTest(unittest.TestCase):
+
+    def test_check(self):
+        errors = compat_mapping_check.check(
+            '34.0', VERSIONED_POLICY,
+            {'plat': PLAT_MAPPING, 'system_ext': SYSTEM_EXT_MAPPING}, [CURRENT])
+        self.assertEqual(len(errors), 4)
+        self.assertIn('baz_34_0 is used by the versioned public policy', errors[0])
+        self.assertIn('of plat, system_ext', errors[0])
+        self.assertIn('34.0: plat 34.0.cil maps bar_34_0 to bar', errors[1])
+        self.assertIn('34.0: system_ext 34.0.cil maps qux_34_0 to racoon', errors[2])
+        self.assertIn('attribute removed_attr', errors[3])
+
+    def test_ok(self):
+        self.assertEqual(compat_mapping_check.check(
+            '34.0', '(allow foo_34_0 self (file (read)))',
+            {'plat': '(typeattributeset foo_34_0 (foo))'}, ['(type foo)']), [])
+
+
+if __name__ == '__main__':
+    unittest.main(verbosity=2)
@@ -0,0 +1,206 @@
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Drafts compat files for a frozen version from current and prebuilt public policy.
+
+Usage:
+    $ compat_mapping_draft -c base_plat_pub_policy.cil -p 202404_plat_pub_policy.cil \\
+        -v 202404 --latest-mapping 34.0.cil --latest-ignore 34.0.ignore.cil \\
+        --mapping 202404.cil --compat 202404.compat.cil --ignore 202404.ignore.cil \\
+        --report compat_report.txt
+
+Types of the prebuilt policy which are still in the current policy are mapped to
+themselves. A removed type is mapped to a new type which looks like its rename,
+or else kept by declaring it in the mapping file. A new type is mapped the same
+way the latest compat files (--latest-mapping, --latest-ignore) map it, or else
+looks like a rename, or else is ignored. The report lists every decision which
+should be reviewed before the files are checked in.
+"""
+
+import argparse
+import difflib
+import sys
+from dataclasses import dataclass, field
+from typing import Dict, List, Set
+
+import compat_templates
+import freeze_report
+import mini_parser
+
+# How similar names of a removed type and a new type must be to draft a rename.
+RENAME_NAME_RATIO = 0.8
+
+@dataclass
+class Draft:
+    """Compat files being drafted for a version."""
+    version: str
+    # prebuilt type -> current types mapped to it
+    mapping: Dict[str, Set[str]] = field(default_factory=dict)
+    # prebuilt types which no longer exist, declared by the mapping file
+    removed: Set[str] = field(default_factory=set)
+    ignored: Set[str] = field(default_factory=set)
+    report: List[str] = field(default_factory=list)
+
+    def versioned(self, type_name):
+        return f"{type_name}_{self.version.replace('.', '_')}"
+
+    def mapping_cil(self):
+        lines = []
+        if self.removed:
+            lines.append(';; types removed from current policy')
+            lines.extend(f"(type {t})" for t in sorted(self.removed))
+            lines.append('')
+        text = '\n'.join(lines) + ('\n' if lines else '')
+        text += compat_templates.MAPPING_HEADER % self.version
+        for t in sorted(self.mapping):
+            text += f"(expandtypeattribute ({self.versioned(t)}) true)\n"
+        for t in sorted(self.mapping):
+            members = ' '.join(sorted(self.mapping[t]))
+            text += f"(typeattributeset {self.versioned(t)} ({members}))\n"
+        return text
+
+    def compat_cil(self):
+        text = compat_templates.compat_cil(self.version)
+        if self.removed:
+            text += (f";; types removed since {self.version}. Add rules here if {self.version} "
+                     "vendors still need them:\n")
+            text += ''.join(f";;   {t}\n" for t in sorted(self.removed))
+        return text
+
+    def ignore_cil(self):
+        return compat_templates.ignore_cil(self.version, self.ignored)
+
+
+def types_of(path) -> Dict[str, Set[str]]:
+    """Returns types of a public policy CIL file, with their attributes."""
+    return {key: set(decl.value.split())
+            for (category, key), decl in freeze_report.parse_cil(path).items()
+            if category == 'type'}
+
+
+def unversioned(versioned_type, version):
+    suffix = '_' + version.replace('.', '_')
+    return versioned_type.removesuffix(suffix) if versioned_type.endswith(suffix) else None
+
+
+def find_rename(name, attrs, candidates: Dict[str, Set[str]]):
+    """Returns the candidate which looks like a rename of a type, or None.
+
+    A rename has the same attributes and a similar name. Ties are ambiguous, so
+    no rename is drafted for them.
+    """
+    best = []
+    best_ratio = RENAME_NAME_RATIO
+    for candidate, candidate_attrs in candidates.items():
+        if candidate_attrs != attrs:
+            continue
+        ratio = difflib.SequenceMatcher(None, name, candidate).ratio()
+        if ratio > best_ratio:
+            best, best_ratio = [candidate], ratio
+        elif ratio == best_ratio and best:
+            best.append(candidate)
+    return best[0] if len(best) == 1 else None
+
+
+def draft(current, prebuilt, version, latest_version=None, latest_mapping=None,
+          latest_ignore=None) -> Draft:
+    """Drafts compat files for version, given types of current and prebuilt public policy.
+
+    latest_mapping and latest_ignore are MiniCilParsers of the compat files of latest_version.
+    """
+    result = Draft(version)
+    new_types = {t: attrs for t, attrs in current.items() if t not in prebuilt}
+    removed_types = {t: attrs for t, attrs in prebuilt.items() if t not in current}
+
+    for t in prebuilt:
+        result.mapping[t] = {t} if t in current else set()
+
+    for new_type in sorted(new_types):
+        if latest_ignore and 'new_objects' in latest_ignore.rTypeattributesets.get(new_type, ()):
+            result.ignored.add(new_type)
+            result.report.append(f"ignored new type {new_type}, as {latest_version} does")
+            continue
+        if latest_mapping and new_type in latest_mapping.rTypeattributesets:
+            targets = {unversioned(a, latest_version)
+                       for a in latest_mapping.rTypeattributesets[new_type]}
+            targets.discard(None)
+            missing = sorted(t for t in targets if t not in prebuilt)
+            if targets and not missing:
+                for target in targets:
+                    result.mapping[target].add(new_type)
+                result.report.append(f"mapped new type {new_type} to {', '.join(sorted(targets))}, "
+                                     f"as {latest_version} does")
+                continue
+            if missing:
+                result.report.append(f"REVIEW: {latest_version} maps new type {new_type} to "
+                                     f"{', '.join(missing)}, which {version} doesn't have")
+        renamed = find_rename(new_type, new_types[new_type], removed_types)
+        if renamed:
+            result.mapping[renamed].add(new_type)
+            del removed_types[renamed]
+            result.report.append(f"REVIEW: mapped new type {new_type} to removed type {renamed}, "
+                                 "which looks like its rename")
+            continue
+        result.ignored.add(new_type)
+        result.report.append(f"REVIEW: ignored new type {new_type}. Map it instead if it "
+                             "replaces a type of " + version)
+
+    for removed_type in sorted(removed_types):
+        result.removed.add(removed_type)
+        result.mapping[removed_type].add(removed_type)
+        result.report.append(f"REVIEW: declared removed type {removed_type}. Map it to a current "
+                             f"type instead if one replaces it, or add rules for it to the "
+                             "compat file")
+
+    return result
+
+
+def do_main(argv):
+    parser = argparse.ArgumentParser()
+    parser.add_argument('-c', '--current', required=True, help='current public policy CIL')
+    parser.add_argument('-p', '--prebuilt', required=True, help='prebuilt public policy CIL')
+    parser.add_argument('-v', '--version', required=True, help='version of the prebuilt policy')
+    parser.add_argument('--latest-version', help='version before --version')
+    parser.add_argument('--latest-mapping', help='mapping CIL of --latest-version')
+    parser.add_argument('--latest-ignore', help='ignore CIL of --latest-version')
+    parser.add_argument('--mapping', required=True, help='output mapping CIL')
+    parser.add_argument('--compat', required=True, help='output compat CIL')
+    parser.add_argument('--ignore', required=True, help='output ignore CIL')
+    parser.add_argument('--report', required=True, help='output review report')
+    args = parser.parse_args(argv)
+
+    if (args.latest_mapping or args.latest_ignore) and not args.latest_version:
+        sys.exit('Error: --latest-version is required with --latest-mapping or --latest-ignore')
+
+    result = draft(
+        types_of(args.current),
+        types_of(args.prebuilt),
+        args.version,
+        args.latest_version,
+        mini_parser.MiniCilParser(args.latest_mapping) if args.latest_mapping else None,
+        mini_parser.MiniCilParser(args.latest_ignore) if args.latest_ignore else None)
+
+    for path, content in [(args.mapping, result.mapping_cil()),
+                          (args.compat, result.compat_cil()),
+                          (args.ignore, result.ignore_cil())]:
+        with open(path, 'w', encoding='utf-8') as f:
+            f.write(content)
+
+    reviews = sum(1 for line in result.report if line.startswith('REVIEW'))
+    with open(args.report, 'w', encoding='utf-8') as f:
+        f.write(f"Draft compat files for {args.version}: {reviews} decisions to review\n")
+        f.writelines(line + '\n' for line in result.report)
+
+
+if __name__ == '__main__':
+    do_main(sys.argv[1:])
@@ -0,0 +1,105 @@
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Tests for compat_mapping_draft"""
+
+import os
+import shutil
+import tempfile
+import unittest
+
+import compat_mapping_draft
+import mini_parser
+
+
+PREBUILT = """
+(type device)
+(type racoon)
+(type foo_hal_service)
+(typeattribute hal_service_type)
+(typeattributeset hal_service_type (foo_hal_service))
+"""
+
+CURRENT = """
+(type device)
+(type vfio_device)
+(type bar_service)
+(type foo_hal_hwservice)
+(typeattribute hal_service_type)
+(typeattributeset hal_service_type (foo_hal_hwservice))
+"""
+
+LATEST_MAPPING = """
+(typeattributeset device_34_0 (device vfio_device))
+"""
+
+LATEST_IGNORE = """
+(typeattributeset new_objects
+  ( new_objects
+    bar_service
+  ))
+"""
+
+
+# pylint: disable=missing-docstring
+class CompatMappingDraftTest(unittest.TestCase):
+
+    def setUp(self):
+        self.temp_dir = tempfile.mkdtemp()
+
+    def tearDown(self):
+        shutil.rmtree(self.temp_dir)
+
+    def write(self, name, content):
+        path = os.path.join(self.temp_dir, name)
+        with open(path, 'w', encoding='utf-8') as f:
+            f.write(content)
+        return path
+
+    def test_draft(self):
+        result = compat_mapping_draft.draft(
+            compat_mapping_draft.types_of(self.write('current.cil', CURRENT)),
+            compat_mapping_draft.types_of(self.write('prebuilt.cil', PREBUILT)),
+            '202404', '34.0',
+            mini_parser.MiniCilParser(self.write('34.0.cil', LATEST_MAPPING)),
+            mini_parser.MiniCilParser(self.write('34.0.ignore.cil', LATEST_IGNORE)))
+
+        self.assertEqual(result.mapping, {
+            'device': {'device', 'vfio_device'},
+            'foo_hal_service': {'foo_hal_hwservice'},
+            'racoon': {'racoon'},
+        })
+        self.assertEqual(result.removed, {'racoon'})
+        self.assertEqual(result.ignored, {'bar_service'})
+        self.assertEqual(len([r for r in result.report if r.startswith('REVIEW')]), 2)
+
+        mapping = result.mapping_cil()
+        self.assertTrue(mapping.startswith(';; types removed from current policy\n(type racoon)\n'))
+        self.assertIn('(expandtypeattribute (device_202404) true)', mapping)
+        self.assertIn('(typeattributeset device_202404 (device vfio_device))', mapping)
+        self.assertIn('(typeattributeset foo_hal_service_202404 (foo_hal_hwservice))',
+                      mapping)
+        self.assertIn(';;   racoon', result.compat_cil())
+
+        ignore = mini_parser.MiniCilParser(self.write('202404.ignore.cil', result.ignore_cil()))
+        self.assertEqual(ignore.typeattributesets['new_objects'], {'new_objects', 'bar_service'})
+
+    def test_no_rename_if_ambiguous(self):
+        result = compat_mapping_draft.draft(
+            {'foo_prop': set()}, {'foo_props': set(), 'foo_prop2': set()}, '202404')
+        self.assertEqual(result.ignored, {'foo_prop'})
+        self.assertEqual(result.removed, {'foo_props', 'foo_prop2'})
+
+
+if __name__ == '__main__':
+    unittest.main(verbosity=2)
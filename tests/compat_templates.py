@@ -0,0 +1,152 @@
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Templates of the files which freezing a sepolicy version adds to the tree.
+
+Usage:
+    $ compat_templates -v 202404 --compat 202404.compat.cil \\
+        --ignore 202404.ignore.cil --prebuilt-api-bp Android.bp
+
+Writes the compat files of a version without any compat rules or ignored types,
+and the Android.bp of prebuilts/api/<ver>. se_freeze_snapshot,
+compat_mapping_draft, sepolicy_generate_compat and finalize-vintf-resources.sh
+all use these templates.
+"""
+
+import argparse
+import sys
+
+MAPPING_HEADER = ";; mapping information from ToT policy's types to %s policy's types.\n"
+
+COMPAT_TEMPLATE = """;; complement CIL file for compatibility between ToT policy and %s vendors.
+;; will be compiled along with other normal policy files, on %s vendors.
+;;
+"""
+
+IGNORE_TEMPLATE = """;; new_objects - a collection of types that have been introduced with ToT policy
+;;   that have no analogue in %s policy.  Thus, we do not need to map these types to
+;;   previous ones.  Add here to pass checkapi tests.
+(type new_objects)
+(typeattribute new_objects)
+(typeattributeset new_objects
+  ( new_objects
+%s  ))
+"""
+
+PREBUILT_API_BP_TEMPLATE = """// Automatically generated file, do not edit!
+se_policy_conf {
+    name: "${ver}_plat_pub_policy.conf",
+    defaults: ["se_policy_conf_flags_defaults"],
+    srcs: [
+        ":se_build_files{.plat_public_${ver}}",
+        ":se_build_files{.reqd_mask}",
+    ],
+    installable: false,
+    build_variant: "user",
+}
+
+se_policy_cil {
+    name: "${ver}_plat_pub_policy.cil",
+    src: ":${ver}_plat_pub_policy.conf",
+    filter_out: [":reqd_policy_mask.cil"],
+    secilc_check: false,
+    installable: false,
+}
+
+se_policy_conf {
+    name: "${ver}_product_pub_policy.conf",
+    defaults: ["se_policy_conf_flags_defaults"],
+    srcs: [
+        ":se_build_files{.plat_public_${ver}}",
+        ":se_build_files{.system_ext_public_${ver}}",
+        ":se_build_files{.product_public_${ver}}",
+        ":se_build_files{.reqd_mask}",
+    ],
+    installable: false,
+    build_variant: "user",
+}
+
+se_policy_cil {
+    name: "${ver}_product_pub_policy.cil",
+    src: ":${ver}_product_pub_policy.conf",
+    filter_out: [":reqd_policy_mask.cil"],
+    secilc_check: false,
+    installable: false,
+}
+
+se_policy_conf {
+    name: "${ver}_plat_policy.conf",
+    defaults: ["se_policy_conf_flags_defaults"],
+    srcs: [
+        ":se_build_files{.plat_public_${ver}}",
+        ":se_build_files{.plat_private_${ver}}",
+        ":se_build_files{.system_ext_public_${ver}}",
+        ":se_build_files{.system_ext_private_${ver}}",
+        ":se_build_files{.product_public_${ver}}",
+        ":se_build_files{.product_private_${ver}}",
+    ],
+    installable: false,
+    build_variant: "user",
+}
+
+se_policy_cil {
+    name: "${ver}_plat_policy.cil",
+    src: ":${ver}_plat_policy.conf",
+    additional_cil_files: [":sepolicy_technical_debt{.plat_private_${ver}}"],
+    installable: false,
+}
+
+se_policy_binary {
+    name: "${ver}_plat_policy",
+    srcs: [":${ver}_plat_policy.cil"],
+    installable: false,
+    dist: {
+        targets: ["base-sepolicy-files-for-mapping"],
+    },
+}
+"""
+
+
+def compat_cil(version):
+    """Returns <ver>.compat.cil without any compat rules."""
+    return COMPAT_TEMPLATE % (version, version)
+
+
+def ignore_cil(version, ignored_types=()):
+    """Returns <ver>.ignore.cil which ignores ignored_types."""
+    return IGNORE_TEMPLATE % (version, ''.join(f"    {t}\n" for t in sorted(ignored_types)))
+
+
+def prebuilt_api_bp(version):
+    """Returns Android.bp of prebuilts/api/<ver>."""
+    return PREBUILT_API_BP_TEMPLATE.replace('${ver}', version)
+
+
+def do_main(argv):
+    parser = argparse.ArgumentParser()
+    parser.add_argument('-v', '--version', required=True, help='version being frozen')
+    parser.add_argument('--compat', help='output compat CIL')
+    parser.add_argument('--ignore', help='output ignore CIL')
+    parser.add_argument('--prebuilt-api-bp', help='output Android.bp of prebuilts/api/<ver>')
+    args = parser.parse_args(argv)
+
+    for path, content in [(args.compat, compat_cil(args.version)),
+                          (args.ignore, ignore_cil(args.version)),
+                          (args.prebuilt_api_bp, prebuilt_api_bp(args.version))]:
+        if path:
+            with open(path, 'w', encoding='utf-8') as f:
+                f.write(content)
+
+
+if __name__ == '__main__':
+    do_main(sys.argv[1:])
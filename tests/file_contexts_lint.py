@@ -0,0 +1,268 @@
+#!/usr/bin/env python3
+#
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""A linter for specs of a built file_contexts file.
+
+Usage:
+    $ file_contexts_lint -o report.txt [-r /vendor ...] [--strict] file_contexts
+
+Reports:
+  - catch-all specs (e.g. /vendor/foo(/.*)?) which cover a whole root directory
+    given with -r, or which aren't under any of them. Such specs relabel far more
+    files than intended and make restorecon slow.
+  - specs which may backtrack excessively, i.e. nested quantifiers like (a+)*
+    or more than one unbounded wildcard.
+  - specs which never take effect, because a later entry matches every path
+    they match. libselinux uses the last matching entry, after moving specs
+    without regex features to the end.
+
+Findings are written to the report. With --strict, any finding is an error.
+"""
+
+import argparse
+import re
+import sys
+from dataclasses import dataclass
+from typing import List, Optional
+
+REGEX_METACHARS = set('.^$?*+|[](){}\\')
+
+# Tails which make a spec match everything under its prefix.
+CATCH_ALL_TAIL = re.compile(r'^(?P<prefix>.*?)(?P<tail>\(/\.\*\)\?|/\(\.\*\)\?|\(\.\*\)\?|/\.\*|\.\*)$')
+
+# Kinds of catch-all tails: the directory and everything in it, e.g. (/.*)?, only
+# everything in the directory, e.g. /.*, and any suffix, e.g. (.*)?
+DIR_AND_CHILDREN = 'dir_and_children'
+CHILDREN = 'children'
+ANY_SUFFIX = 'any_suffix'
+TAIL_KINDS = {
+    '(/.*)?': DIR_AND_CHILDREN,
+    '/(.*)?': CHILDREN,
+    '/.*': CHILDREN,
+    '(.*)?': ANY_SUFFIX,
+    '.*': ANY_SUFFIX,
+}
+
+# A group containing a quantifier, which is quantified itself, e.g. (a+)* or (.*/)+
+NESTED_QUANTIFIER = re.compile(r'\((?:[^()\\]|\\.)*[*+](?:[^()\\]|\\.)*\)[*+]')
+
+# An unescaped unbounded wildcard.
+UNBOUNDED_WILDCARD = re.compile(r'(?<!\\)\.[*+]')
+
+# A group of literal alternatives, e.g. (vendor|system/vendor) or (64)?
+LITERAL_GROUP = re.compile(r'\(([^()]*)\)(\?)?')
+
+
+@dataclass
+class Entry:
+    """A single file_contexts entry."""
+    path: str
+    lineno: int
+    spec: str
+    file_type: Optional[str]
+    context: str
+
+    def location(self):
+        return f"{self.path}:{self.lineno}"
+
+
+def parse_file(path) -> List[Entry]:
+    entries = []
+    with open(path, 'r', encoding='utf-8') as f:
+        for lineno, line in enumerate(f, 1):
+            line = line.strip()
+            if not line or line.startswith('#'):
+                continue
+            split = line.split()
+            if len(split) == 2:
+                entries.append(Entry(path, lineno, split[0], None, split[1]))
+            elif len(split) == 3:
+                entries.append(Entry(path, lineno, split[0], split[1], split[2]))
+    return entries
+
+
+def literal_value(spec) -> Optional[str]:
+    """Returns the path which spec matches, if spec has no regex features."""
+    value = []
+    i = 0
+    while i < len(spec):
+        c = spec[i]
+        if c == '\\':
+            if i + 1 >= len(spec):
+                return None
+            value.append(spec[i + 1])
+            i += 2
+            continue
+        if c in REGEX_METACHARS:
+            return None
+        value.append(c)
+        i += 1
+    return ''.join(value)
+
+
+def expand_prefix(prefix) -> Optional[List[str]]:
+    """Expands groups of literal alternatives in prefix into literal paths.
+
+    For example, /(vendor|system/vendor)/lib(64)? expands into four paths.
+    Returns None if prefix has any other regex features.
+    """
+    match = LITERAL_GROUP.search(prefix)
+    if not match:
+        value = literal_value(prefix)
+        return [value] if value is not None else None
+
+    alternatives = match.group(1).split('|')
+    if match.group(2):
+        alternatives.append('')
+    head = literal_value(prefix[:match.start()])
+    tails = expand_prefix(prefix[match.end():])
+    if head is None or tails is None:
+        return None
+
+    ret = []
+    for alternative in alternatives:
+        value = literal_value(alternative)
+        if value is None:
+            return None
+        ret.extend(head + value + tail for tail in tails)
+    return ret
+
+
+def parse_catch_all(spec) -> (Optional[List[str]], Optional[str]):
+    """Returns (prefixes, tail kind) of a catch-all spec, or (None, None)."""
+    match = CATCH_ALL_TAIL.match(spec)
+    if not match:
+        return None, None
+    prefixes = expand_prefix(match.group('prefix'))
+    if prefixes is None:
+        return None, None
+    return prefixes, TAIL_KINDS[match.group('tail')]
+
+
+def covers_prefix(later_prefix, later_kind, earlier_prefix, earlier_kind) -> bool:
+    """Returns whether a catch-all matches every path another catch-all matches."""
+    if later_kind == ANY_SUFFIX:
+        return earlier_prefix.startswith(later_prefix)
+    later_dir = later_prefix.rstrip('/')
+    earlier_dir = earlier_prefix.rstrip('/')
+    if earlier_prefix.startswith(later_dir + '/') and earlier_dir != later_dir:
+        return True
+    if earlier_prefix != later_prefix or earlier_kind == ANY_SUFFIX:
+        return False
+    return later_kind == DIR_AND_CHILDREN or earlier_kind == CHILDREN
+
+
+def check_catch_all(entry: Entry, roots) -> List[str]:
+    """Returns findings if a catch-all spec covers a root, or is outside of all roots.
+
+    The default label of a partition, e.g. /(vendor|system/vendor)(/.*)?, labels the
+    root itself along with everything in it, and is required; it isn't reported.
+    """
+    prefixes, kind = parse_catch_all(entry.spec)
+    if not roots or not prefixes:
+        return []
+    prefixes = [p.rstrip('/') or '/' for p in prefixes]
+    if kind == DIR_AND_CHILDREN and any(prefix in roots for prefix in prefixes):
+        return []
+    findings = []
+    for prefix in prefixes:
+        if prefix in roots:
+            findings.append(f"{entry.location()}: [catch-all] {entry.spec} covers all of "
+                            f"{prefix}; label specific subdirectories instead")
+        elif not any(prefix.startswith(root.rstrip('/') + '/') for root in roots):
+            findings.append(f"{entry.location()}: [catch-all] {entry.spec} covers {prefix}, "
+                            f"which is outside of the expected roots {', '.join(roots)}")
+    return findings
+
+
+def check_backtracking(entry: Entry) -> List[str]:
+    """Returns findings if the spec may backtrack excessively."""
+    spec = entry.spec
+    if NESTED_QUANTIFIER.search(spec):
+        return [f"{entry.location()}: [backtracking] {spec} has nested quantifiers"]
+    if len(UNBOUNDED_WILDCARD.findall(spec)) > 1:
+        return [f"{entry.location()}: [backtracking] {spec} has more than one unbounded "
+                f"wildcard; use [^/]* for a single path component"]
+    return []
+
+
+def covers(later: Entry, earlier: Entry) -> bool:
+    """Returns whether later matches every path which earlier matches."""
+    if later.file_type is not None and later.file_type != earlier.file_type:
+        return False
+    if later.spec == earlier.spec:
+        return True
+
+    earlier_prefixes, earlier_kind = parse_catch_all(earlier.spec)
+    later_prefixes, later_kind = parse_catch_all(later.spec)
+    if not earlier_prefixes or not later_prefixes:
+        return False
+    return all(any(covers_prefix(l, later_kind, e, earlier_kind) for l in later_prefixes)
+               for e in earlier_prefixes)
+
+
+def lookup_order(entries: List[Entry]) -> List[Entry]:
+    """Returns entries in the order libselinux considers them, last match first.
+
+    Like libselinux, entries without regex features are moved after all regexes,
+    so that they take precedence.
+    """
+    regexes = [e for e in entries if literal_value(e.spec) is None]
+    literals = [e for e in entries if literal_value(e.spec) is not None]
+    return regexes + literals
+
+
+def check_shadowed(entries: List[Entry]) -> List[str]:
+    """Returns findings for entries which are overridden by a later entry."""
+    findings = []
+    entries = lookup_order(entries)
+    for i, earlier in enumerate(entries):
+        for later in entries[i + 1:]:
+            if covers(later, earlier):
+                findings.append(f"{earlier.location()}: [shadowed] {earlier.spec} never takes "
+                                f"effect, because {later.spec} ({later.location()}) matches "
+                                f"all of its paths")
+                break
+    return findings
+
+
+def lint(entries: List[Entry], roots) -> List[str]:
+    findings = []
+    for entry in entries:
+        findings.extend(check_catch_all(entry, roots))
+        findings.extend(check_backtracking(entry))
+    findings.extend(check_shadowed(entries))
+    return findings
+
+
+def do_main(argv):
+    parser = argparse.ArgumentParser()
+    parser.add_argument('-o', '--output', required=True, help='report to write')
+    parser.add_argument('-r', '--root', action='append', default=[],
+                        help='directory which catch-all specs are expected to be under')
+    parser.add_argument('--strict', action='store_true', help='fail if anything is reported')
+    parser.add_argument('file_contexts')
+    args = parser.parse_args(argv)
+
+    findings = lint(parse_file(args.file_contexts), args.root)
+    with open(args.output, 'w', encoding='utf-8') as f:
+        f.writelines(finding + '\n' for finding in findings)
+
+    if findings and args.strict:
+        sys.exit('file_contexts lint failed:\n' + '\n'.join(findings))
+
+
+if __name__ == '__main__':
+    do_main(sys.argv[1:])
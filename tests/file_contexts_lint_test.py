@@ -0,0 +1,96 @@
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Tests for file_contexts_lint"""
+
+import os
+import tempfile
+import unittest
+
+import file_contexts_lint as lint
+
+
+def entry(spec, file_type=None, lineno=1):
+    return lint.Entry('fc', lineno, spec, file_type, 'u:object_r:foo:s0')
+
+
+# pylint: disable=missing-docstring
+class FileContextsLintTest(unittest.TestCase):
+
+    def test_expand_prefix(self):
+        self.assertEqual(sorted(lint.expand_prefix('/(vendor|system/vendor)/lib(64)?')),
+                         ['/system/vendor/lib', '/system/vendor/lib64',
+                          '/vendor/lib', '/vendor/lib64'])
+        self.assertEqual(lint.expand_prefix('/vendor/lib\\.so'), ['/vendor/lib.so'])
+        self.assertIsNone(lint.expand_prefix('/vendor/[a-z]+'))
+
+    def test_catch_all(self):
+        roots = ['/vendor', '/data/vendor']
+        self.assertEqual(lint.check_catch_all(entry('/vendor/foo(/.*)?'), roots), [])
+        self.assertEqual(lint.check_catch_all(entry('/data/vendor/foo(/.*)?'), roots), [])
+        self.assertIn('covers all of /vendor',
+                      lint.check_catch_all(entry('/vendor/(.*)?'), roots)[0])
+        self.assertIn('outside of the expected roots',
+                      lint.check_catch_all(entry('/(vendor|odm)/foo(/.*)?'), roots)[0])
+        # Without roots, catch-all specs aren't checked.
+        self.assertEqual(lint.check_catch_all(entry('/vendor/(.*)?'), []), [])
+
+    def test_partition_root_default(self):
+        with tempfile.TemporaryDirectory() as temp_dir:
+            path = os.path.join(temp_dir, 'file_contexts')
+            with open(path, 'w', encoding='utf-8') as f:
+                f.write('/(vendor|system/vendor)(/.*)?                  u:object_r:vendor_file:s0\n')
+                f.write('/(odm|vendor/odm)(/.*)?                       u:object_r:vendor_file:s0\n')
+            entries = lint.parse_file(path)
+        self.assertEqual(lint.lint(entries, ['/vendor', '/odm']), [])
+        # Only the default of the root itself is exempt.
+        self.assertIn('covers all of /vendor',
+                      lint.check_catch_all(entry('/(vendor|system/vendor)/.*'), ['/vendor'])[0])
+
+    def test_backtracking(self):
+        self.assertEqual(lint.check_backtracking(entry('/vendor/foo(/.*)?')), [])
+        self.assertEqual(lint.check_backtracking(entry('/vendor/lib(/[^/]+){0,2}')), [])
+        self.assertIn('nested quantifiers', lint.check_backtracking(entry('/vendor/(a+)*'))[0])
+        self.assertIn('more than one unbounded wildcard',
+                      lint.check_backtracking(entry('/vendor/.*/foo/.*'))[0])
+
+    def test_shadowed(self):
+        findings = lint.check_shadowed([
+            entry('/vendor/foo/bar(/.*)?', lineno=1),
+            entry('/vendor/foo(/.*)?', lineno=2),
+            entry('/vendor/baz', lineno=3),
+            entry('/vendor/baz', lineno=4),
+        ])
+        self.assertEqual(len(findings), 2)
+        self.assertTrue(findings[0].startswith('fc:1: [shadowed]'))
+        self.assertTrue(findings[1].startswith('fc:3: [shadowed]'))
+
+    def test_not_shadowed(self):
+        self.assertEqual(lint.check_shadowed([
+            # A broader entry is overridden by more specific ones, not the other way around.
+            entry('/vendor/foo(/.*)?'),
+            entry('/vendor/foo/bar(/.*)?'),
+            # Exact paths take precedence over regexes, regardless of the order.
+            entry('/vendor/bin/baz'),
+            entry('/vendor/bin(/.*)?'),
+            # Entries restricted to a file type only override the same file type.
+            entry('/vendor/qux(/.*)?'),
+            entry('/vendor/qux(/.*)?', file_type='--'),
+            # /foo(.*)? also matches /foobar.
+            entry('/vendor/quux(.*)?'),
+            entry('/vendor/quux(/.*)?'),
+        ]), [])
+
+
+if __name__ == '__main__':
+    unittest.main(verbosity=2)
@@ -0,0 +1,396 @@
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Computes semantic differences between current and prebuilt public policy.
+
+Policy is read either from a CIL file, e.g. base_plat_pub_policy.cil, or from a
+directory of policy sources, e.g. system_ext/public. Both are turned into
+declarations of a category ("type", "attribute", "macro", "contexts" or
+"statement") with a key and a value, and compared by key. Directories are also
+compared file by file, like `diff -r -q`, as the semantic comparison only
+explains differences and doesn't catch all of them.
+"""
+
+import filecmp
+import fnmatch
+import os
+import re
+from dataclasses import dataclass
+from typing import Dict, List, Optional
+
+TYPE_PATTERN = re.compile(r'type\s+(\w+)\s*(?:,\s*(.*))?', re.S)
+ATTRIBUTE_PATTERN = re.compile(r'attribute\s+(\w+)')
+TYPEATTRIBUTE_PATTERN = re.compile(r'typeattribute\s+(\w+)\s+(.*)', re.S)
+DEFINE_PATTERN = re.compile(r'define\(`(\w+)\'')
+CONTEXT_PATTERN = re.compile(r'u:(object_r|r):')
+
+# Statements such as whole recovery_only() blocks are shortened in reports.
+MAX_DESCRIPTION_LENGTH = 200
+
+# Attributes which secilc generates, whose names aren't stable.
+GENERATED_ATTRIBUTE_PREFIX = 'base_typeattr_'
+
+
+@dataclass
+class Decl:
+    """A declaration of policy, e.g. a type with its attributes."""
+    category: str
+    key: str
+    location: str
+    value: str = ''
+
+
+@dataclass
+class Delta:
+    """A difference between current and prebuilt policy."""
+    change: str  # added, removed or changed
+    category: str
+    key: str
+    location: str
+    detail: str = ''
+    prebuilt_location: Optional[str] = None
+
+    def describe(self):
+        """Returns a stable description, e.g. "removed type foo"."""
+        return f"{self.change} {self.category} {self.key}"
+
+    def __str__(self):
+        description = self.describe()
+        if len(description) > MAX_DESCRIPTION_LENGTH:
+            description = description[:MAX_DESCRIPTION_LENGTH] + '...'
+        text = f"{self.location}: {description}"
+        if self.detail:
+            text += f" ({self.detail})"
+        if self.prebuilt_location:
+            text += f", prebuilt at {self.prebuilt_location}"
+        return text
+
+    def paths(self):
+        """Returns the files which the delta was found in."""
+        locations = [self.location, self.prebuilt_location]
+        return {location.rpartition(':')[0] for location in locations if location}
+
+
+@dataclass
+class FileDiff:
+    """A file which was added, removed or changed byte-wise."""
+    change: str  # added, removed or changed
+    current: str
+    prebuilt: str
+
+    def explains(self, delta: Delta):
+        """Returns whether the delta was found in this file."""
+        return bool(delta.paths() & {self.current, self.prebuilt})
+
+    def __str__(self):
+        # The same as the output of `diff -r -q`.
+        if self.change == 'added':
+            return f"Only in {os.path.dirname(self.current)}: {os.path.basename(self.current)}"
+        if self.change == 'removed':
+            return f"Only in {os.path.dirname(self.prebuilt)}: {os.path.basename(self.prebuilt)}"
+        return f"Files {self.current} and {self.prebuilt} differ"
+
+
+@dataclass
+class FreezeException:
+    """A delta which is allowed after the freeze."""
+    bug: str
+    delta: str  # Delta.describe() of the allowed delta
+    reason: str
+    location: str
+
+    def __str__(self):
+        return f"{self.location}: b/{self.bug} \"{self.delta}\" ({self.reason})"
+
+
+def parse_exceptions(path):
+    """Returns (exceptions, errors) of an exceptions file.
+
+    Each line is <bug id> "<delta>" <reason>, e.g.
+        123456789 "added statement neverallow foo bar:file write" Tightens a neverallow.
+    """
+    exceptions = []
+    errors = []
+    with open(path, 'r', encoding='utf-8') as f:
+        for lineno, line in enumerate(f, 1):
+            line = line.strip()
+            if not line or line.startswith('#'):
+                continue
+            location = f"{path}:{lineno}"
+            bug, _, rest = line.partition(' ')
+            bug = bug.removeprefix('b/')
+            rest = rest.strip()
+            delta, quoted, reason = rest[1:].partition('"')
+            if not bug.isdigit():
+                errors.append(f"{location}: \"{bug}\" is not a bug ID")
+            elif not rest.startswith('"') or not quoted:
+                errors.append(f"{location}: the delta must be quoted")
+            elif not reason.strip():
+                errors.append(f"{location}: a reason is required")
+            else:
+                exceptions.append(FreezeException(bug, delta, reason.strip(), location))
+    return exceptions, errors
+
+
+def add_decl(decls: Dict, decl: Decl):
+    # The first declaration wins; statements may be repeated across files.
+    decls.setdefault((decl.category, decl.key), decl)
+
+
+def cil_statements(text):
+    """Yields (line, statement) of top-level CIL statements, without the outer parens."""
+    depth = 0
+    start = None
+    line = 1
+    start_line = 1
+    i = 0
+    while i < len(text):
+        c = text[i]
+        if c == ';':
+            while i < len(text) and text[i] != '\n':
+                i += 1
+            continue
+        if c == '\n':
+            line += 1
+        elif c == '(':
+            if depth == 0:
+                start = i + 1
+                start_line = line
+            depth += 1
+        elif c == ')':
+            depth -= 1
+            if depth == 0:
+                yield start_line, text[start:i]
+        i += 1
+
+
+def parse_cil(path) -> Dict:
+    """Returns declarations of a CIL file, keyed by (category, key)."""
+    with open(path, 'r', encoding='utf-8') as f:
+        text = f.read()
+
+    decls = {}
+    type_attributes = {}
+    for line, stmt in cil_statements(text):
+        location = f"{path}:{line}"
+        tokens = stmt.replace('(', ' ( ').replace(')', ' ) ').split()
+        if not tokens:
+            continue
+        if tokens[0] == 'type' and len(tokens) == 2:
+            add_decl(decls, Decl('type', tokens[1], location))
+        elif tokens[0] == 'typeattribute' and len(tokens) == 2:
+            if not tokens[1].startswith(GENERATED_ATTRIBUTE_PREFIX):
+                add_decl(decls, Decl('attribute', tokens[1], location))
+        elif tokens[0] == 'typeattributeset':
+            if tokens[1].startswith(GENERATED_ATTRIBUTE_PREFIX):
+                continue
+            for member in tokens[2:]:
+                if member not in '()':
+                    type_attributes.setdefault(member, set()).add(tokens[1])
+        elif tokens[0] != 'expandtypeattribute':
+            add_decl(decls, Decl('statement', ' '.join(tokens), location))
+
+    for (category, key), decl in decls.items():
+        if category == 'type':
+            decl.value = ' '.join(sorted(type_attributes.get(key, [])))
+    return decls
+
+
+def extract_macros(path, text, decls):
+    """Records m4 macro definitions of text, and returns text without them.
+
+    Definitions are replaced by blank lines, so that line numbers don't change.
+    """
+    result = []
+    pos = 0
+    for m in DEFINE_PATTERN.finditer(text):
+        if m.start() < pos:
+            continue
+        # Find the closing paren of define(), skipping quoted text.
+        quote = 0
+        depth = 1
+        i = m.start() + len('define(')
+        while i < len(text) and depth > 0:
+            c = text[i]
+            if c == '`':
+                quote += 1
+            elif c == "'" and quote > 0:
+                quote -= 1
+            elif quote == 0 and c == '(':
+                depth += 1
+            elif quote == 0 and c == ')':
+                depth -= 1
+            i += 1
+        body = text[m.end():i - 1]
+        line = text.count('\n', 0, m.start()) + 1
+        add_decl(decls, Decl('macro', m.group(1), f"{path}:{line}", ' '.join(body.split())))
+        result.append(text[pos:m.start()])
+        result.append('\n' * text.count('\n', m.start(), i))
+        pos = i
+    result.append(text[pos:])
+    return ''.join(result)
+
+
+def te_statements(text):
+    """Yields (line, statement) of policy sources.
+
+    A statement ends with ";", or at the end of a line which closes a macro
+    call, like init_daemon_domain(foo).
+    """
+    stmt = []
+    start_line = None
+    depth = 0
+    for lineno, line in enumerate(text.split('\n'), 1):
+        line = line.split('#', 1)[0]
+        for c in line:
+            if start_line is None:
+                if c.isspace():
+                    continue
+                start_line = lineno
+            stmt.append(c)
+            if c in '({':
+                depth += 1
+            elif c in ')}':
+                depth -= 1
+            elif c == ';' and depth == 0:
+                yield start_line, ''.join(stmt)
+                stmt = []
+                start_line = None
+        if start_line is not None and depth == 0 and ''.join(stmt).rstrip().endswith(')'):
+            yield start_line, ''.join(stmt)
+            stmt = []
+            start_line = None
+        elif start_line is not None:
+            stmt.append(' ')
+    if start_line is not None:
+        yield start_line, ''.join(stmt)
+
+
+def parse_te(path, text, decls, type_attributes):
+    for line, stmt in te_statements(text):
+        location = f"{path}:{line}"
+        stmt = ' '.join(stmt.rstrip(';').split())
+        m = TYPE_PATTERN.fullmatch(stmt)
+        if m:
+            attributes = [a.strip() for a in (m.group(2) or '').split(',') if a.strip()]
+            type_attributes.setdefault(m.group(1), set()).update(attributes)
+            add_decl(decls, Decl('type', m.group(1), location))
+            continue
+        m = ATTRIBUTE_PATTERN.fullmatch(stmt)
+        if m:
+            add_decl(decls, Decl('attribute', m.group(1), location))
+            continue
+        m = TYPEATTRIBUTE_PATTERN.fullmatch(stmt)
+        if m:
+            attributes = [a.strip() for a in m.group(2).split(',') if a.strip()]
+            type_attributes.setdefault(m.group(1), set()).update(attributes)
+            continue
+        add_decl(decls, Decl('statement', stmt, location))
+
+
+def parse_contexts(path, name, text, decls):
+    """Records entries of a contexts file, keyed by what they label."""
+    for lineno, line in enumerate(text.split('\n'), 1):
+        line = line.split('#', 1)[0].strip()
+        if not line:
+            continue
+        fields = line.split()
+        index = next((i for i, f in enumerate(fields) if CONTEXT_PATTERN.match(f)), None)
+        if index is None:
+            # e.g. seapp_contexts, whose entries are all selectors
+            key, value = line, ''
+        else:
+            key, value = ' '.join(fields[:index]), ' '.join(fields[index:])
+        add_decl(decls, Decl('contexts', f"{name} {key}", f"{path}:{lineno}", value))
+
+
+def is_excluded(rel_path, excludes):
+    return any(fnmatch.fnmatch(rel_path, pattern) or
+               fnmatch.fnmatch(os.path.basename(rel_path), pattern) for pattern in excludes)
+
+
+def list_files(root, excludes) -> List[str]:
+    """Returns paths of files under a directory relative to it, except for excluded ones."""
+    files = []
+    for dirpath, _, filenames in os.walk(root):
+        for filename in filenames:
+            rel_path = os.path.relpath(os.path.join(dirpath, filename), root)
+            if not is_excluded(rel_path, excludes):
+                files.append(rel_path)
+    return files
+
+
+def diff_files(current_root, prebuilt_root, excludes) -> List[FileDiff]:
+    """Returns files which were added, removed or changed byte-wise, like `diff -r -q`."""
+    current_files = set(list_files(current_root, excludes))
+    prebuilt_files = set(list_files(prebuilt_root, excludes))
+    diffs = []
+    for rel_path in sorted(current_files | prebuilt_files):
+        current = os.path.join(current_root, rel_path)
+        prebuilt = os.path.join(prebuilt_root, rel_path)
+        if rel_path not in prebuilt_files:
+            diffs.append(FileDiff('added', current, prebuilt))
+        elif rel_path not in current_files:
+            diffs.append(FileDiff('removed', current, prebuilt))
+        elif not filecmp.cmp(current, prebuilt, shallow=False):
+            diffs.append(FileDiff('changed', current, prebuilt))
+    return diffs
+
+
+def parse_dir(root, excludes) -> Dict:
+    """Returns declarations of the policy sources under a directory, keyed by (category, key)."""
+    decls = {}
+    type_attributes = {}
+    for dirpath, _, filenames in os.walk(root):
+        for filename in sorted(filenames):
+            path = os.path.join(dirpath, filename)
+            rel_path = os.path.relpath(path, root)
+            if is_excluded(rel_path, excludes):
+                continue
+            with open(path, 'r', encoding='utf-8') as f:
+                text = f.read()
+            if filename.endswith('contexts'):
+                parse_contexts(path, rel_path, text, decls)
+            else:
+                parse_te(path, extract_macros(path, text, decls), decls, type_attributes)
+
+    for (category, key), decl in decls.items():
+        if category == 'type':
+            decl.value = ' '.join(sorted(type_attributes.get(key, [])))
+    return decls
+
+
+def compare(current: Dict, prebuilt: Dict) -> List[Delta]:
+    """Returns differences between current and prebuilt declarations."""
+    deltas = []
+    for key in sorted(set(current) | set(prebuilt)):
+        cur = current.get(key)
+        pre = prebuilt.get(key)
+        if pre is None:
+            deltas.append(Delta('added', cur.category, cur.key, cur.location))
+        elif cur is None:
+            deltas.append(Delta('removed', pre.category, pre.key, pre.location))
+        elif cur.value != pre.value:
+            if cur.category == 'type':
+                cur_attrs = set(cur.value.split())
+                pre_attrs = set(pre.value.split())
+                detail = ' '.join([f"+{a}" for a in sorted(cur_attrs - pre_attrs)] +
+                                  [f"-{a}" for a in sorted(pre_attrs - cur_attrs)])
+                detail = 'attributes ' + detail
+            elif cur.category == 'macro':
+                detail = 'definition changed'
+            else:
+                detail = f"was \"{pre.value}\", now \"{cur.value}\""
+            deltas.append(Delta('changed', cur.category, cur.key, cur.location, detail,
+                                pre.location))
+    return deltas
@@ -0,0 +1,183 @@
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Tests for freeze_report"""
+
+import os
+import shutil
+import tempfile
+import unittest
+
+import freeze_report
+
+
+PREBUILT_CIL = """
+(type foo)
+(type bar)
+(typeattribute foo_attr)
+(typeattributeset foo_attr (foo bar))
+(typeattribute base_typeattr_1)
+(typeattributeset base_typeattr_1 (foo))
+(allow foo bar (file (read)))
+"""
+
+CURRENT_CIL = """
+(type foo)
+(type baz)
+(typeattribute foo_attr)
+(typeattributeset foo_attr (baz))
+; comment (type ignored)
+(typeattribute base_typeattr_2)
+(allow foo bar (file (read)))
+"""
+
+PREBUILT_TE = """
+type foo, domain;
+attribute foo_attr;
+init_daemon_domain(foo)
+allow foo bar:file {
+    read open };
+"""
+
+CURRENT_TE = """
+# comment
+type foo, domain, foo_attr;
+attribute foo_attr;
+init_daemon_domain(foo)
+allow foo bar:file {
+    read open };
+typeattribute foo mlstrustedsubject;
+"""
+
+MACROS = """
+define(`foo_domain', `
+type $1, domain;
+allow $1 self:process fork;
+')
+define(`bar_domain', `foo_domain($1)')
+"""
+
+PREBUILT_CONTEXTS = """
+/system/bin/foo    u:object_r:foo_exec:s0
+/system/bin/bar    u:object_r:bar_exec:s0
+"""
+
+CURRENT_CONTEXTS = """
+/system/bin/foo    u:object_r:foo_exec:s0
+/system/bin/bar    u:object_r:foo_exec:s0
+/system/bin/baz    u:object_r:baz_exec:s0
+"""
+
+
+# pylint: disable=missing-docstring
+class FreezeReportTest(unittest.TestCase):
+
+    def setUp(self):
+        self.temp_dir = tempfile.mkdtemp()
+
+    def tearDown(self):
+        shutil.rmtree(self.temp_dir)
+
+    def write(self, name, content):
+        path = os.path.join(self.temp_dir, name)
+        os.makedirs(os.path.dirname(path), exist_ok=True)
+        with open(path, 'w', encoding='utf-8') as f:
+            f.write(content)
+        return path
+
+    def test_cil(self):
+        deltas = freeze_report.compare(
+            freeze_report.parse_cil(self.write('current.cil', CURRENT_CIL)),
+            freeze_report.parse_cil(self.write('prebuilt.cil', PREBUILT_CIL)))
+        self.assertEqual([(d.describe(), d.detail) for d in deltas], [
+            ('removed type bar', ''),
+            ('added type baz', ''),
+            ('changed type foo', 'attributes -foo_attr'),
+        ])
+        self.assertTrue(deltas[0].location.endswith('prebuilt.cil:3'))
+        self.assertTrue(deltas[1].location.endswith('current.cil:3'))
+
+    def test_dir(self):
+        self.write('current/foo.te', CURRENT_TE)
+        self.write('current/te_macros', MACROS.replace('fork', 'sigchld'))
+        self.write('current/file_contexts', CURRENT_CONTEXTS)
+        self.write('current/bug_map', 'foo bar')
+        self.write('prebuilt/foo.te', PREBUILT_TE)
+        self.write('prebuilt/te_macros', MACROS)
+        self.write('prebuilt/file_contexts', PREBUILT_CONTEXTS)
+
+        current = freeze_report.parse_dir(os.path.join(self.temp_dir, 'current'), ['bug_map'])
+        prebuilt = freeze_report.parse_dir(os.path.join(self.temp_dir, 'prebuilt'), [])
+        deltas = freeze_report.compare(current, prebuilt)
+        self.assertEqual([d.describe() for d in deltas], [
+            'changed contexts file_contexts /system/bin/bar',
+            'added contexts file_contexts /system/bin/baz',
+            'changed macro foo_domain',
+            'changed type foo',
+        ])
+        self.assertIn('was "u:object_r:bar_exec:s0"', deltas[0].detail)
+        self.assertTrue(deltas[0].location.endswith('file_contexts:3'))
+        self.assertTrue(deltas[2].location.endswith('te_macros:2'))
+        self.assertEqual(deltas[3].detail, 'attributes +foo_attr +mlstrustedsubject')
+        self.assertTrue(deltas[3].location.endswith('foo.te:3'))
+
+    def test_diff_files(self):
+        self.write('current/foo.te', CURRENT_TE)
+        self.write('current/bar.te', 'allow bar baz:file  read;\n')
+        self.write('current/file_contexts', PREBUILT_CONTEXTS)
+        self.write('current/bug_map', 'foo bar')
+        self.write('prebuilt/foo.te', PREBUILT_TE)
+        self.write('prebuilt/bar.te', 'allow bar baz:file read;\n')
+        self.write('prebuilt/file_contexts', PREBUILT_CONTEXTS)
+        self.write('prebuilt/te_macros', MACROS)
+
+        current = os.path.join(self.temp_dir, 'current')
+        prebuilt = os.path.join(self.temp_dir, 'prebuilt')
+        diffs = freeze_report.diff_files(current, prebuilt, ['bug_map'])
+        self.assertEqual([(d.change, os.path.basename(d.current)) for d in diffs], [
+            ('changed', 'bar.te'),
+            ('changed', 'foo.te'),
+            ('removed', 'te_macros'),
+        ])
+        self.assertEqual(str(diffs[2]), f"Only in {prebuilt}: te_macros")
+
+        # The whitespace change of bar.te isn't a delta, but the file still differs.
+        deltas = freeze_report.compare(freeze_report.parse_dir(current, ['bug_map']),
+                                       freeze_report.parse_dir(prebuilt, []))
+        self.assertFalse(any(diffs[0].explains(d) for d in deltas))
+        self.assertTrue(any(diffs[1].explains(d) for d in deltas))
+
+    def test_exceptions(self):
+        exceptions, errors = freeze_report.parse_exceptions(self.write('exceptions', (
+            '# comment\n'
+            '123 "added statement neverallow foo bar:file write" Tightens a neverallow.\n'
+            'b/456 "removed type foo"   Reason.\n'
+            'foo "removed type foo" Reason.\n'
+            '789 removed type foo\n'
+            '789 "removed type foo"\n')))
+        self.assertEqual([(e.bug, e.delta, e.reason) for e in exceptions], [
+            ('123', 'added statement neverallow foo bar:file write', 'Tightens a neverallow.'),
+            ('456', 'removed type foo', 'Reason.'),
+        ])
+        self.assertEqual(len(errors), 3)
+        self.assertIn('exceptions:4: "foo" is not a bug ID', errors[0])
+        self.assertIn('must be quoted', errors[1])
+        self.assertIn('reason is required', errors[2])
+
+    def test_statements(self):
+        statements = list(freeze_report.te_statements(PREBUILT_TE))
+        self.assertEqual([line for line, _ in statements], [2, 3, 4, 5])
+
+
+if __name__ == '__main__':
+    unittest.main(verbosity=2)
@@ -4,6 +4,7 @@ extern "C" {
 #endif
 
 int get_allow_rule(char *out, size_t len, void *policydbp, void *avtab_iterp);
+int get_type_transition_rule(char *out, size_t len, void *policydbp, void *avtab_iterp);
 void *load_policy(const char *policy_path);
 void destroy_policy(void *policydbp);
 void *init_avtab(void *policydbp);
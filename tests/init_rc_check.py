@@ -0,0 +1,191 @@
+#!/usr/bin/env python3
+#
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Checks that init can start services of .rc files under enforcing mode.
+
+Usage:
+    $ init_rc_check -p precompiled_sepolicy -f plat_file_contexts \\
+        -f vendor_file_contexts foo.rc bar.rc
+
+For each service, either its seclabel must name a domain, or the label of its
+binary must be an exec type which init transitions from, typically with
+init_daemon_domain(). Otherwise init refuses to start the service.
+
+Binaries under /apex are skipped, as they're labeled by APEX file_contexts.
+"""
+
+import argparse
+import os
+import pkgutil
+import shlex
+import sys
+import tempfile
+from dataclasses import dataclass
+from typing import List, Optional
+
+import label_manifest
+import policy
+
+SHARED_LIB_EXTENSION = '.dylib' if sys.platform == 'darwin' else '.so'
+LIBSEPOLWRAP = "libsepolwrap" + SHARED_LIB_EXTENSION
+
+SECTION_KEYWORDS = {'service', 'on', 'import'}
+
+
+@dataclass
+class Service:
+    """A service section of an .rc file."""
+    location: str
+    name: str
+    binary: str
+    seclabel: Optional[str] = None
+
+
+@dataclass
+class PolicyInfo:
+    """The parts of the policy which matter to starting services."""
+    all_types: set
+    domains: set
+    exec_types: set
+    # Exec types which init transitions from, to the domain they map to.
+    init_transitions: dict
+
+
+def read_lines(path):
+    """Yields (lineno, tokens) of an .rc file, joining continued lines."""
+    with open(path, 'r', encoding='utf-8') as f:
+        pending = ''
+        start = 0
+        for lineno, line in enumerate(f, 1):
+            line = line.rstrip('\n')
+            if not pending:
+                start = lineno
+            if line.endswith('\\'):
+                pending += line[:-1] + ' '
+                continue
+            line = (pending + line).strip()
+            pending = ''
+            if not line or line.startswith('#'):
+                continue
+            try:
+                tokens = shlex.split(line)
+            except ValueError:
+                tokens = line.split()
+            yield start, tokens
+
+
+def parse_rc(path) -> (List[Service], List[str]):
+    """Returns (services, errors) of an .rc file."""
+    services = []
+    errors = []
+    current = None
+    for lineno, tokens in read_lines(path):
+        keyword = tokens[0]
+        if keyword in SECTION_KEYWORDS:
+            current = None
+            if keyword != 'service':
+                continue
+            if len(tokens) < 3:
+                errors.append(f"{path}:{lineno}: service needs a name and a binary")
+                continue
+            current = Service(f"{path}:{lineno}", tokens[1], tokens[2])
+            services.append(current)
+        elif keyword == 'seclabel' and current is not None:
+            if len(tokens) != 2:
+                errors.append(f"{path}:{lineno}: seclabel needs exactly one context")
+                continue
+            current.seclabel = tokens[1]
+    return services, errors
+
+
+def context_type(context) -> Optional[str]:
+    split = context.split(':')
+    return split[2] if len(split) >= 3 else None
+
+
+def check_service(service: Service, specs, info: PolicyInfo) -> List[str]:
+    """Returns an error if init couldn't start the service in its own domain."""
+    prefix = f"{service.location}: service {service.name}"
+    if service.seclabel is not None:
+        domain = context_type(service.seclabel)
+        if domain is None:
+            return [f"{prefix}: invalid seclabel {service.seclabel}"]
+        if domain not in info.all_types:
+            return [f"{prefix}: seclabel names {domain}, which is not declared in the policy"]
+        if domain not in info.domains:
+            return [f"{prefix}: seclabel names {domain}, which is not a domain"]
+        return []
+
+    if service.binary.startswith('/apex/'):
+        return []
+
+    spec = label_manifest.lookup(specs, service.binary, None)
+    if spec is None:
+        return [f"{prefix}: {service.binary} has no label"]
+    exec_type = label_manifest.label_type(spec.context)
+    if exec_type not in info.exec_types:
+        return [f"{prefix}: {service.binary} is labeled {exec_type} ({spec.location}), which is "
+                f"not an exec_type. Add a file_contexts entry with an exec type, or a seclabel."]
+    if exec_type not in info.init_transitions:
+        return [f"{prefix}: init doesn't transition to any domain from {exec_type}. "
+                f"Use init_daemon_domain(), or add a seclabel."]
+    return []
+
+
+def load_policy_info(pol) -> PolicyInfo:
+    init_transitions = {}
+    for rule in pol.QueryTypeTransition(scontext='init', tclass='process'):
+        init_transitions[rule.tctx] = rule.default
+    return PolicyInfo(
+        all_types=pol.GetAllTypes(False),
+        domains=pol.QueryTypeAttribute('domain', True),
+        exec_types=pol.QueryTypeAttribute('exec_type', True),
+        init_transitions=init_transitions,
+    )
+
+
+def do_main(work_dir):
+    parser = argparse.ArgumentParser()
+    parser.add_argument('-p', '--policy', required=True, help='precompiled sepolicy')
+    parser.add_argument('-f', '--file_contexts', action='append', required=True,
+                        help='file_contexts, in the order libselinux loads them')
+    parser.add_argument('--allow', action='append', default=[],
+                        help='service which is known not to start in its own domain')
+    parser.add_argument('rc', nargs='+', help='.rc files')
+    args = parser.parse_args()
+
+    lib_path = os.path.join(work_dir, LIBSEPOLWRAP)
+    with open(lib_path, 'wb') as f:
+        blob = pkgutil.get_data('init_rc_check', LIBSEPOLWRAP)
+        if not blob:
+            sys.exit("Error: libsepolwrap does not exist. Is this binary corrupted?\n")
+        f.write(blob)
+    info = load_policy_info(policy.Policy(args.policy, None, lib_path))
+
+    specs, errors = label_manifest.load_specs(args.file_contexts)
+    for rc in args.rc:
+        services, rc_errors = parse_rc(rc)
+        errors.extend(rc_errors)
+        for service in services:
+            if service.name not in args.allow:
+                errors.extend(check_service(service, specs, info))
+
+    if errors:
+        sys.exit('Services which would fail to start under enforcing mode:\n' + '\n'.join(errors))
+
+
+if __name__ == '__main__':
+    with tempfile.TemporaryDirectory() as temp_dir:
+        do_main(temp_dir)
@@ -0,0 +1,111 @@
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Tests for init_rc_check"""
+
+import os
+import shutil
+import tempfile
+import unittest
+
+import init_rc_check
+import label_manifest
+
+
+FILE_CONTEXTS = """
+/vendor(/.*)?                  u:object_r:vendor_file:s0
+/vendor/bin/foo                u:object_r:foo_exec:s0
+/vendor/bin/bar                u:object_r:bar_exec:s0
+/vendor/bin/data               u:object_r:vendor_data_file:s0
+"""
+
+RC = """
+# comment
+on boot
+    start foo
+
+service foo /vendor/bin/foo
+    class hal
+    user system
+
+service bar /vendor/bin/bar --flag \\
+        --other-flag
+    oneshot
+
+service baz /vendor/bin/baz
+    disabled
+
+service sh /vendor/bin/sh
+    seclabel u:r:vendor_shell:s0
+
+service typo /vendor/bin/sh
+    seclabel u:r:vendr_shell:s0
+
+service data /vendor/bin/data
+
+service apex /apex/com.android.foo/bin/foo
+"""
+
+
+# pylint: disable=missing-docstring
+class InitRcCheckTest(unittest.TestCase):
+
+    def setUp(self):
+        self.temp_dir = tempfile.mkdtemp()
+        self.specs, _ = label_manifest.load_specs([self.write('file_contexts', FILE_CONTEXTS)])
+        self.info = init_rc_check.PolicyInfo(
+            all_types={'foo', 'foo_exec', 'bar_exec', 'vendor_shell', 'vendor_file',
+                       'vendor_data_file'},
+            domains={'foo', 'vendor_shell'},
+            exec_types={'foo_exec', 'bar_exec'},
+            init_transitions={'foo_exec': 'foo'},
+        )
+
+    def tearDown(self):
+        shutil.rmtree(self.temp_dir)
+
+    def write(self, name, content):
+        path = os.path.join(self.temp_dir, name)
+        with open(path, 'w', encoding='utf-8') as f:
+            f.write(content)
+        return path
+
+    def test_parse(self):
+        services, errors = init_rc_check.parse_rc(self.write('foo.rc', RC))
+        self.assertEqual(errors, [])
+        self.assertEqual([(s.name, s.binary, s.seclabel) for s in services], [
+            ('foo', '/vendor/bin/foo', None),
+            ('bar', '/vendor/bin/bar', None),
+            ('baz', '/vendor/bin/baz', None),
+            ('sh', '/vendor/bin/sh', 'u:r:vendor_shell:s0'),
+            ('typo', '/vendor/bin/sh', 'u:r:vendr_shell:s0'),
+            ('data', '/vendor/bin/data', None),
+            ('apex', '/apex/com.android.foo/bin/foo', None),
+        ])
+        self.assertTrue(services[1].location.endswith('foo.rc:10'))
+
+    def test_check(self):
+        services, _ = init_rc_check.parse_rc(self.write('foo.rc', RC))
+        errors = {s.name: init_rc_check.check_service(s, self.specs, self.info)
+                  for s in services}
+        self.assertEqual(errors['foo'], [])
+        self.assertIn("init doesn't transition", errors['bar'][0])
+        self.assertIn('labeled vendor_file', errors['baz'][0])
+        self.assertEqual(errors['sh'], [])
+        self.assertIn('not declared in the policy', errors['typo'][0])
+        self.assertIn('not an exec_type', errors['data'][0])
+        self.assertEqual(errors['apex'], [])
+
+
+if __name__ == '__main__':
+    unittest.main(verbosity=2)
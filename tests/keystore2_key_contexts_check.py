@@ -0,0 +1,152 @@
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Validates keystore2_key_contexts files against a precompiled sepolicy.
+
+Usage:
+    $ keystore2_key_contexts_check precompiled_sepolicy \
+        plat_keystore2_key_contexts vendor_keystore2_key_contexts ...
+
+Every entry must have a namespace in the interval [0, 2^31), and its target
+type must be declared in the policy with the keystore2_key_type attribute.
+A namespace may be declared only once across all given files, because keystore2
+merges the files of every partition into a single lookup table at runtime.
+"""
+
+import os
+import pkgutil
+import re
+import shutil
+import sys
+import tempfile
+from dataclasses import dataclass
+
+import policy
+
+SHARED_LIB_EXTENSION = '.dylib' if sys.platform == 'darwin' else '.so'
+LIBSEPOLWRAP = "libsepolwrap" + SHARED_LIB_EXTENSION
+
+KEY_TYPE_ATTRIBUTE = "keystore2_key_type"
+MAX_NAMESPACE = 2 ** 31
+
+# A line should look like:
+# {namespace} u:object_r:{type}:s0
+line_regex = re.compile(r'^(\S+)\s+u:object_r:([^:\s]+):s0\s*$')
+
+
+@dataclass
+class Entry:
+    """A single namespace declaration in a keystore2_key_contexts file."""
+    path: str
+    lineno: int
+    namespace: int
+    label: str
+
+    def location(self):
+        return f"{self.path}:{self.lineno}"
+
+
+def parse_file(path):
+    """Parses a keystore2_key_contexts file. Returns (entries, errors)."""
+    entries = []
+    errors = []
+    with open(path, 'r', encoding='utf-8') as f:
+        for lineno, line in enumerate(f, 1):
+            line = line.strip()
+            if not line or line.startswith('#'):
+                continue
+
+            matched = line_regex.match(line)
+            if not matched:
+                errors.append(f"{path}:{lineno}: malformed entry \"{line}\"")
+                continue
+
+            namespace, label = matched.group(1, 2)
+            if not namespace.isdigit() or (len(namespace) > 1 and namespace[0] == '0'):
+                errors.append(f"{path}:{lineno}: namespace \"{namespace}\" is not a "
+                              "non-negative decimal integer")
+                continue
+            if int(namespace) >= MAX_NAMESPACE:
+                errors.append(f"{path}:{lineno}: namespace {namespace} is out of range "
+                              f"[0, {MAX_NAMESPACE})")
+                continue
+
+            entries.append(Entry(path, lineno, int(namespace), label))
+    return entries, errors
+
+
+def check_unique_namespaces(entries):
+    """Returns an error for each namespace which is declared more than once."""
+    errors = []
+    seen = {}
+    for entry in entries:
+        if entry.namespace in seen:
+            first = seen[entry.namespace]
+            errors.append(f"{entry.location()}: namespace {entry.namespace} "
+                          f"({entry.label}) is already declared at "
+                          f"{first.location()} ({first.label})")
+        else:
+            seen[entry.namespace] = entry
+    return errors
+
+
+def check_labels(entries, all_types, key_types):
+    """Returns an error for each entry whose type isn't a keystore2_key_type."""
+    errors = []
+    for entry in entries:
+        if entry.label not in all_types:
+            errors.append(f"{entry.location()}: type {entry.label} is not declared in the policy")
+        elif entry.label not in key_types:
+            errors.append(f"{entry.location()}: type {entry.label} must be associated with "
+                          f"the \"{KEY_TYPE_ATTRIBUTE}\" attribute")
+    return errors
+
+
+def do_main(libpath):
+    if len(sys.argv) < 3:
+        sys.exit(f"usage: {sys.argv[0]} sepolicy keystore2_key_contexts...")
+
+    policy_path = sys.argv[1]
+    contexts_paths = sys.argv[2:]
+    for path in [policy_path] + contexts_paths:
+        if not os.path.exists(path):
+            sys.exit(f"Error: {path} does not exist")
+
+    entries = []
+    errors = []
+    for path in contexts_paths:
+        file_entries, file_errors = parse_file(path)
+        entries.extend(file_entries)
+        errors.extend(file_errors)
+
+    pol = policy.Policy(policy_path, None, libpath)
+    errors.extend(check_labels(entries, pol.GetAllTypes(False),
+                               pol.QueryTypeAttribute(KEY_TYPE_ATTRIBUTE, True)))
+    errors.extend(check_unique_namespaces(entries))
+
+    if errors:
+        sys.exit("keystore2_key_contexts violations found:\n" + "\n".join(errors))
+
+
+if __name__ == '__main__':
+    temp_dir = tempfile.mkdtemp()
+    try:
+        libpath = os.path.join(temp_dir, LIBSEPOLWRAP)
+        with open(libpath, "wb") as f:
+            blob = pkgutil.get_data("keystore2_key_contexts_check", LIBSEPOLWRAP)
+            if not blob:
+                sys.exit("Error: libsepolwrap does not exist. Is this binary corrupted?\n")
+            f.write(blob)
+        do_main(libpath)
+    finally:
+        shutil.rmtree(temp_dir)
@@ -0,0 +1,80 @@
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Tests for keystore2_key_contexts_check"""
+
+import os
+import shutil
+import tempfile
+import unittest
+
+import keystore2_key_contexts_check as check
+
+
+# pylint: disable=missing-docstring
+class Keystore2KeyContextsCheckTest(unittest.TestCase):
+
+    def setUp(self):
+        self.temp_dir = tempfile.mkdtemp()
+
+    def tearDown(self):
+        shutil.rmtree(self.temp_dir)
+
+    def write(self, name, content):
+        path = os.path.join(self.temp_dir, name)
+        with open(path, 'w', encoding='utf-8') as f:
+            f.write(content)
+        return path
+
+    def test_parse(self):
+        path = self.write('plat', '# comment\n\n0 u:object_r:su_key:s0\n100   u:object_r:vold_key:s0\n')
+        entries, errors = check.parse_file(path)
+        self.assertEqual(errors, [])
+        self.assertEqual([(e.namespace, e.label, e.lineno) for e in entries],
+                         [(0, 'su_key', 3), (100, 'vold_key', 4)])
+
+    def test_malformed_namespace(self):
+        path = self.write('vendor', '-1 u:object_r:a_key:s0\n0x10 u:object_r:b_key:s0\n'
+                          '007 u:object_r:c_key:s0\n2147483648 u:object_r:d_key:s0\n'
+                          '2147483647 u:object_r:e_key:s0\n')
+        entries, errors = check.parse_file(path)
+        self.assertEqual(len(errors), 4)
+        self.assertEqual([e.namespace for e in entries], [2147483647])
+
+    def test_malformed_line(self):
+        path = self.write('vendor', '100 vold_key\n')
+        _, errors = check.parse_file(path)
+        self.assertEqual(len(errors), 1)
+        self.assertIn('malformed entry', errors[0])
+
+    def test_duplicated_namespace_across_partitions(self):
+        plat, _ = check.parse_file(self.write('plat', '100 u:object_r:vold_key:s0\n'))
+        vendor, _ = check.parse_file(self.write('vendor', '101 u:object_r:a_key:s0\n'
+                                                '100 u:object_r:b_key:s0\n'))
+        errors = check.check_unique_namespaces(plat + vendor)
+        self.assertEqual(len(errors), 1)
+        self.assertIn('namespace 100 (b_key)', errors[0])
+        self.assertIn('plat:1 (vold_key)', errors[0])
+
+    def test_labels(self):
+        entries, _ = check.parse_file(self.write('plat', '0 u:object_r:su_key:s0\n'
+                                                 '1 u:object_r:shell:s0\n'
+                                                 '2 u:object_r:missing_key:s0\n'))
+        errors = check.check_labels(entries, {'su_key', 'shell'}, {'su_key'})
+        self.assertEqual(len(errors), 2)
+        self.assertIn('shell must be associated', errors[0])
+        self.assertIn('missing_key is not declared', errors[1])
+
+
+if __name__ == '__main__':
+    unittest.main(verbosity=2)
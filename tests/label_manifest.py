@@ -0,0 +1,268 @@
+#!/usr/bin/env python3
+#
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Computes the label of every file of a partition, like selabel_lookup would.
+
+Usage:
+    $ label_manifest -f plat_file_contexts -f vendor_file_contexts \\
+        --installed-files installed-files-vendor.txt -o manifest.txt \\
+        --defaults-report defaults.txt
+    $ label_manifest -f plat_file_contexts -f vendor_file_contexts \\
+        --staged-dir out/target/product/foo/vendor --root /vendor -o manifest.txt
+
+file_contexts files must be given in the order libselinux loads them (plat,
+system_ext, product, vendor, odm). The manifest has a line "<path> <context>"
+for every file. Files which get one of the default labels (e.g. vendor_file),
+because no specific entry matches them, are listed in the defaults report.
+
+Executables which get one of the default labels, unless they match an --allow
+regex, are listed in the executables report. Such executables have no exec type,
+so init can't start them in their own domain. With --check-executables, they
+are errors instead. Without file modes, e.g. with --installed-files, every file
+in a bin or xbin directory is considered an executable, including symlinks to
+toybox, so the report may list files which are fine.
+"""
+
+import argparse
+import os
+import re
+import stat
+import sys
+from dataclasses import dataclass
+from typing import List, Optional
+
+DEFAULT_LABELS = ['system_file', 'vendor_file']
+
+# Characters which make libselinux treat a spec as a regex rather than an exact path.
+META_CHARS = set('.^$?*+|[({')
+
+# File type flags of file_contexts, and the matching stat file types.
+FILE_TYPES = {
+    '--': stat.S_IFREG,
+    '-d': stat.S_IFDIR,
+    '-l': stat.S_IFLNK,
+    '-c': stat.S_IFCHR,
+    '-b': stat.S_IFBLK,
+    '-s': stat.S_IFSOCK,
+    '-p': stat.S_IFIFO,
+}
+
+
+@dataclass
+class Spec:
+    """A single file_contexts entry."""
+    location: str
+    regex: re.Pattern
+    file_type: Optional[int]
+    context: str
+
+
+def has_meta_chars(spec) -> bool:
+    """Returns whether libselinux treats spec as a regex."""
+    i = 0
+    while i < len(spec):
+        if spec[i] == '\\':
+            i += 2
+            continue
+        if spec[i] in META_CHARS:
+            return True
+        i += 1
+    return False
+
+
+def load_specs(paths) -> (List[Spec], List[str]):
+    """Loads file_contexts files in lookup order. Returns (specs, errors).
+
+    Like libselinux, exact paths are moved after all regexes. Lookups use the
+    last matching spec.
+    """
+    regexes = []
+    exact = []
+    errors = []
+    for path in paths:
+        with open(path, 'r', encoding='utf-8') as f:
+            for lineno, line in enumerate(f, 1):
+                line = line.strip()
+                if not line or line.startswith('#'):
+                    continue
+                location = f"{path}:{lineno}"
+                split = line.split()
+                if len(split) not in (2, 3) or (len(split) == 3 and split[1] not in FILE_TYPES):
+                    errors.append(f"{location}: invalid file_contexts entry \"{line}\"")
+                    continue
+                try:
+                    regex = re.compile(split[0])
+                except re.error as e:
+                    errors.append(f"{location}: invalid regex {split[0]}: {e}")
+                    continue
+                file_type = FILE_TYPES[split[1]] if len(split) == 3 else None
+                spec = Spec(location, regex, file_type, split[-1])
+                (regexes if has_meta_chars(split[0]) else exact).append(spec)
+    return regexes + exact, errors
+
+
+def lookup(specs: List[Spec], path, file_type) -> Optional[Spec]:
+    """Returns the spec which labels path, or None if nothing matches.
+
+    file_type is a stat file type, or None if it's unknown, in which case specs
+    restricted to a file type other than a regular file don't match.
+    """
+    file_type = file_type if file_type is not None else stat.S_IFREG
+    for spec in reversed(specs):
+        if spec.file_type is not None and spec.file_type != file_type:
+            continue
+        if spec.regex.fullmatch(path):
+            return spec
+    return None
+
+
+def label_type(context) -> str:
+    """Returns the type of a context, e.g. vendor_file for u:object_r:vendor_file:s0."""
+    split = context.split(':')
+    return split[2] if len(split) >= 3 else context
+
+
+def read_installed_files(path, root) -> List[tuple]:
+    """Returns (path, mode) of files in an installed files list.
+
+    Each line has a path, optionally preceded by the file size as in
+    installed-files-*.txt. Relative paths are relative to root. Modes are
+    unknown, so they're None.
+    """
+    files = []
+    with open(path, 'r', encoding='utf-8') as f:
+        for line in f:
+            split = line.split()
+            if not split:
+                continue
+            files.append((device_path(root, split[-1]), None))
+    return files
+
+
+def walk_staged_dir(staged_dir, root) -> List[tuple]:
+    """Returns (path, mode) of all files in a staged partition directory."""
+    files = [(device_path(root, ''), os.lstat(staged_dir).st_mode)]
+    for dirpath, dirnames, filenames in os.walk(staged_dir):
+        for name in sorted(dirnames) + sorted(filenames):
+            host_path = os.path.join(dirpath, name)
+            rel = os.path.relpath(host_path, staged_dir)
+            files.append((device_path(root, rel), os.lstat(host_path).st_mode))
+        dirnames.sort()
+    return files
+
+
+def device_path(root, path) -> str:
+    if path.startswith('/'):
+        return path
+    return '/' + '/'.join(p for p in (root.strip('/'), path.strip('/')) if p)
+
+
+def resolve(specs: List[Spec], files) -> List[tuple]:
+    """Returns (path, mode, spec) for each file, sorted by path."""
+    ret = []
+    for path, mode in sorted(files):
+        file_type = stat.S_IFMT(mode) if mode is not None else None
+        ret.append((path, mode, lookup(specs, path, file_type)))
+    return ret
+
+
+def is_executable(path, mode) -> bool:
+    """Returns whether the file is an executable.
+
+    If the mode is unknown, files in bin and xbin directories are executables.
+    """
+    if mode is not None:
+        return stat.S_ISREG(mode) and bool(mode & (stat.S_IXUSR | stat.S_IXGRP | stat.S_IXOTH))
+    dirs = path.split('/')[:-1]
+    return 'bin' in dirs or 'xbin' in dirs
+
+
+def check_executables(resolved, default_labels, allowlist) -> List[str]:
+    """Returns an error for each executable which gets a default label."""
+    allowed = [re.compile(pattern) for pattern in allowlist]
+    errors = []
+    for path, mode, spec in resolved:
+        if not is_executable(path, mode) or any(a.fullmatch(path) for a in allowed):
+            continue
+        if spec is None:
+            errors.append(f"{path}: executable has no label")
+        elif label_type(spec.context) in default_labels:
+            errors.append(f"{path}: executable has the generic label {label_type(spec.context)} "
+                          f"({spec.location}). Add a file_contexts entry with an exec type.")
+    return errors
+
+
+def find_defaults(resolved, default_labels) -> List[str]:
+    """Returns a finding for each file which gets a default label."""
+    findings = []
+    for path, _, spec in resolved:
+        if spec is None:
+            findings.append(f"{path}: no file_contexts entry matches")
+        elif label_type(spec.context) in default_labels:
+            findings.append(f"{path}: {label_type(spec.context)} ({spec.location})")
+    return findings
+
+
+def write_lines(path, lines):
+    with open(path, 'w', encoding='utf-8') as f:
+        f.writelines(line + '\n' for line in lines)
+
+
+def do_main(argv):
+    parser = argparse.ArgumentParser()
+    parser.add_argument('-f', '--file_contexts', action='append', required=True,
+                        help='file_contexts, in the order libselinux loads them')
+    files = parser.add_mutually_exclusive_group(required=True)
+    files.add_argument('--installed-files', help='list of installed files')
+    files.add_argument('--staged-dir', help='staged partition directory')
+    parser.add_argument('--root', default='/', help='where the partition is mounted')
+    parser.add_argument('--default-label', action='append',
+                        help=f"default labels (default: {', '.join(DEFAULT_LABELS)})")
+    parser.add_argument('-o', '--output', required=True, help='manifest to write')
+    parser.add_argument('--defaults-report', help='report of files with default labels')
+    parser.add_argument('--executables-report',
+                        help='report of executables with default labels')
+    parser.add_argument('--check-executables', action='store_true',
+                        help='fail if an executable gets a default label')
+    parser.add_argument('--allow', action='append', default=[],
+                        help='regex of executables which may get a default label')
+    args = parser.parse_args(argv)
+
+    specs, errors = load_specs(args.file_contexts)
+    if errors:
+        sys.exit('\n'.join(errors))
+
+    if args.installed_files:
+        files = read_installed_files(args.installed_files, args.root)
+    else:
+        files = walk_staged_dir(args.staged_dir, args.root)
+    resolved = resolve(specs, files)
+
+    default_labels = args.default_label or DEFAULT_LABELS
+    errors = check_executables(resolved, default_labels, args.allow)
+    if args.executables_report:
+        write_lines(args.executables_report, errors)
+    if errors and args.check_executables:
+        sys.exit('\n'.join(errors))
+
+    write_lines(args.output, [f"{path} {spec.context if spec else '<<none>>'}"
+                              for path, _, spec in resolved])
+    if args.defaults_report:
+        write_lines(args.defaults_report,
+                    find_defaults(resolved, default_labels))
+
+
+if __name__ == '__main__':
+    do_main(sys.argv[1:])
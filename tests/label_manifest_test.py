@@ -0,0 +1,136 @@
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Tests for label_manifest"""
+
+import os
+import shutil
+import stat
+import tempfile
+import unittest
+
+import label_manifest
+
+
+PLAT_FILE_CONTEXTS = """
+/(vendor|system/vendor)(/.*)?          u:object_r:vendor_file:s0
+/(vendor|system/vendor)/bin/sh         u:object_r:vendor_shell_exec:s0
+/(vendor|system/vendor)/bin/toolbox    u:object_r:vendor_toolbox_exec:s0
+"""
+
+VENDOR_FILE_CONTEXTS = """
+/vendor/bin/hw/foo-service             u:object_r:hal_foo_default_exec:s0
+/vendor/bin/hw(/.*)?                   u:object_r:vendor_hal_file:s0
+/vendor/bin/sh                         u:object_r:vendor_file:s0
+/vendor/etc/foo                 -d     u:object_r:vendor_foo_dir:s0
+"""
+
+
+# pylint: disable=missing-docstring
+class LabelManifestTest(unittest.TestCase):
+
+    def setUp(self):
+        self.temp_dir = tempfile.mkdtemp()
+        self.specs, errors = label_manifest.load_specs([
+            self.write('plat_file_contexts', PLAT_FILE_CONTEXTS),
+            self.write('vendor_file_contexts', VENDOR_FILE_CONTEXTS),
+        ])
+        self.assertEqual(errors, [])
+
+    def tearDown(self):
+        shutil.rmtree(self.temp_dir)
+
+    def write(self, name, content):
+        path = os.path.join(self.temp_dir, name)
+        with open(path, 'w', encoding='utf-8') as f:
+            f.write(content)
+        return path
+
+    def label(self, path, file_type=None):
+        spec = label_manifest.lookup(self.specs, path, file_type)
+        return label_manifest.label_type(spec.context) if spec else None
+
+    def test_exact_paths_take_precedence(self):
+        # The exact path wins over the later, broader regex.
+        self.assertEqual(self.label('/vendor/bin/hw/foo-service'), 'hal_foo_default_exec')
+        self.assertEqual(self.label('/vendor/bin/hw/bar-service'), 'vendor_hal_file')
+
+    def test_last_match_wins(self):
+        self.assertEqual(self.label('/vendor/bin/sh'), 'vendor_file')
+        self.assertEqual(self.label('/vendor/bin/toolbox'), 'vendor_toolbox_exec')
+
+    def test_file_type(self):
+        self.assertEqual(self.label('/vendor/etc/foo', stat.S_IFDIR), 'vendor_foo_dir')
+        self.assertEqual(self.label('/vendor/etc/foo', stat.S_IFREG), 'vendor_file')
+        self.assertEqual(self.label('/vendor/etc/foo'), 'vendor_file')
+
+    def test_no_match(self):
+        self.assertIsNone(self.label('/data/foo'))
+
+    def test_installed_files(self):
+        path = self.write('installed', '1024  /vendor/bin/hw/foo-service\nbin/bar\n\n')
+        files = label_manifest.read_installed_files(path, '/vendor')
+        self.assertEqual(files, [('/vendor/bin/hw/foo-service', None), ('/vendor/bin/bar', None)])
+        findings = label_manifest.find_defaults(label_manifest.resolve(self.specs, files),
+                                                label_manifest.DEFAULT_LABELS)
+        self.assertEqual(len(findings), 1)
+        self.assertTrue(findings[0].startswith('/vendor/bin/bar: vendor_file'))
+
+    def test_staged_dir(self):
+        staged = os.path.join(self.temp_dir, 'vendor')
+        os.makedirs(os.path.join(staged, 'etc', 'foo'))
+        self.write(os.path.join('vendor', 'etc', 'foo', 'a'), '')
+        files = label_manifest.walk_staged_dir(staged, '/vendor')
+        self.assertEqual([path for path, _ in files],
+                         ['/vendor', '/vendor/etc', '/vendor/etc/foo', '/vendor/etc/foo/a'])
+        resolved = label_manifest.resolve(self.specs, files)
+        self.assertEqual([label_manifest.label_type(spec.context) for _, _, spec in resolved],
+                         ['vendor_file', 'vendor_file', 'vendor_foo_dir', 'vendor_file'])
+
+    def test_check_executables(self):
+        files = [
+            ('/vendor/bin/hw/foo-service', None),
+            ('/vendor/bin/mydaemon', None),
+            ('/vendor/bin/allowed', None),
+            ('/vendor/etc/foo.rc', None),
+            ('/vendor/etc/script.sh', stat.S_IFREG | 0o755),
+            ('/vendor/bin/data', stat.S_IFREG | 0o644),
+        ]
+        errors = label_manifest.check_executables(label_manifest.resolve(self.specs, files),
+                                                  label_manifest.DEFAULT_LABELS,
+                                                  ['/vendor/bin/allow.*'])
+        self.assertEqual(len(errors), 2)
+        self.assertTrue(errors[0].startswith('/vendor/bin/mydaemon: executable has the generic'))
+        self.assertTrue(errors[1].startswith('/vendor/etc/script.sh: executable has the generic'))
+
+    def test_executables_report(self):
+        args = [
+            '-f', os.path.join(self.temp_dir, 'plat_file_contexts'),
+            '--installed-files', self.write('installed', 'bin/mydaemon\nbin/sh\n'),
+            '--root', '/vendor',
+            '-o', os.path.join(self.temp_dir, 'manifest'),
+            '--executables-report', os.path.join(self.temp_dir, 'executables'),
+        ]
+        # Without --check-executables, executables with default labels are only reported.
+        label_manifest.do_main(args)
+        with open(os.path.join(self.temp_dir, 'executables'), encoding='utf-8') as f:
+            report = f.read().splitlines()
+        self.assertEqual(len(report), 1)
+        self.assertTrue(report[0].startswith('/vendor/bin/mydaemon: executable has the generic'))
+
+        with self.assertRaises(SystemExit):
+            label_manifest.do_main(args + ['--check-executables'])
+
+
+if __name__ == '__main__':
+    unittest.main(verbosity=2)
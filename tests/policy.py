@@ -95,9 +95,19 @@ class TERule:
         self.perms = set((data[4].strip()).split(' '))
         self.rule = rule
 
+class TypeTransitionRule:
+    def __init__(self, rule):
+        data = rule.split(',')
+        self.sctx = data[1]
+        self.tctx = data[2]
+        self.tclass = data[3]
+        self.default = data[4]
+        self.rule = rule
+
 class Policy:
     __ExpandedRules = set()
     __Rules = set()
+    __TypeTransitionRules = None
     __FcDict = None
     __FcSorted = None
     __GenfsDict = None
@@ -293,6 +303,25 @@ class Policy:
             if self.__TERuleMatch(Rule, **kwargs):
                 yield Rule
 
+    # Return all type_transition rules for processes or objects created by
+    # scontext (if given) from tcontext (if given) of class tclass (if given).
+    # Types aren't resolved to attributes, as binary policies only have type
+    # rules.
+    #
+    # Example: QueryTypeTransition(scontext="init", tclass="process")
+    # Will return the domains which init transitions to, via rule.default.
+    def QueryTypeTransition(self, scontext=None, tcontext=None, tclass=None):
+        if self.__TypeTransitionRules is None:
+            self.__InitTypeTransitionRules()
+        for Rule in self.__TypeTransitionRules:
+            if scontext is not None and Rule.sctx != scontext:
+                continue
+            if tcontext is not None and Rule.tctx != tcontext:
+                continue
+            if tclass is not None and Rule.tclass != tclass:
+                continue
+            yield Rule
+
     def GetAllTypes(self, isAttr):
         TypeIterP = self.__libsepolwrap.init_type_iter(self.__policydbP, None, isAttr)
         if (TypeIterP == None):
@@ -386,6 +415,32 @@ class Policy:
         self.__GetTERules(self.__policydbP, avtabIterP, self.__Rules)
         self.__libsepolwrap.destroy_avtab(avtabIterP)
 
+    def __GetTypeTransitionRules(self, avtabIterP, Rules):
+        buf = create_string_buffer(self.__BUFSIZE)
+        while True:
+            ret = self.__libsepolwrap.get_type_transition_rule(buf, self.__BUFSIZE,
+                        self.__policydbP, avtabIterP)
+            if ret == 0:
+                Rules.append(TypeTransitionRule(buf.value.decode("ascii")))
+                continue
+            if ret == 1:
+                break
+            # We should never get here.
+            sys.exit("Failed to import policy")
+
+    def __InitTypeTransitionRules(self):
+        self.__TypeTransitionRules = []
+        avtabIterP = self.__libsepolwrap.init_avtab(self.__policydbP)
+        if (avtabIterP == None):
+            sys.exit("Failed to initialize avtab")
+        self.__GetTypeTransitionRules(avtabIterP, self.__TypeTransitionRules)
+        self.__libsepolwrap.destroy_avtab(avtabIterP)
+        avtabIterP = self.__libsepolwrap.init_cond_avtab(self.__policydbP)
+        if (avtabIterP == None):
+            sys.exit("Failed to initialize conditional avtab")
+        self.__GetTypeTransitionRules(avtabIterP, self.__TypeTransitionRules)
+        self.__libsepolwrap.destroy_avtab(avtabIterP)
+
     def __InitExpandedTERules(self):
         avtabIterP = self.__libsepolwrap.init_expanded_avtab(self.__policydbP)
         if (avtabIterP == None):
@@ -405,6 +460,9 @@ class Policy:
         # int get_allow_rule(char *out, size_t len, void *policydbp, void *avtab_iterp);
         lib.get_allow_rule.restype = c_int
         lib.get_allow_rule.argtypes = [c_char_p, c_size_t, c_void_p, c_void_p];
+        # int get_type_transition_rule(char *out, size_t len, void *policydbp, void *avtab_iterp);
+        lib.get_type_transition_rule.restype = c_int
+        lib.get_type_transition_rule.argtypes = [c_char_p, c_size_t, c_void_p, c_void_p];
         # void *load_policy(const char *policy_path);
         lib.load_policy.restype = c_void_p
         lib.load_policy.argtypes = [c_char_p]
@@ -0,0 +1,216 @@
+#!/usr/bin/env python3
+#
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Finds seapp_contexts entries of different partitions which match the same apps.
+
+Usage:
+    $ seapp_contexts_analysis -f system=plat_seapp_contexts \\
+        -f vendor=vendor_seapp_contexts -o report.txt
+
+checkseapp checks each partition's seapp_contexts on its own, so entries of
+two partitions may match the same app with different domains, and the
+precedence rules of libselinux (seapp_context_cmp()) decide silently which one
+wins. This reports every such pair and its winner, and fails when:
+  - a vendor, odm or product entry wins over a system or system_ext entry for a
+    platform app, i.e. an app which the platform entry names, which is signed
+    with a platform seinfo (--platform-seinfo), or the system server.
+  - entries of two partitions have identical selectors, so no rule orders them.
+"""
+
+import argparse
+import sys
+from dataclasses import dataclass, field
+from typing import Dict, List, Optional
+
+PLATFORM_PARTITIONS = ['system', 'system_ext']
+PARTITIONS = PLATFORM_PARTITIONS + ['product', 'vendor', 'odm']
+
+# Partitions whose entries come before vendor entries in rule (9) of seapp_context_cmp().
+PLATFORM_FILES = {'system', 'system_ext', 'product'}
+
+STRING_SELECTORS = ['user', 'seinfo', 'name']
+# Boolean selectors which default to false, and those which match anything if unspecified.
+FALSE_SELECTORS = ['issystemserver', 'fromrunas', 'isisolatedcomputeapp', 'issdksandboxnext',
+                   'issdksandboxaudit']
+OPTIONAL_SELECTORS = ['isephemeralapp', 'isprivapp']
+INPUT_SELECTORS = STRING_SELECTORS + FALSE_SELECTORS + OPTIONAL_SELECTORS + ['mintargetsdkversion']
+
+
+@dataclass
+class SeappEntry:
+    """A single seapp_contexts entry of a partition."""
+    location: str
+    partition: str
+    line: str
+    selectors: Dict[str, str] = field(default_factory=dict)
+    domain: Optional[str] = None
+
+    def get_bool(self, key) -> Optional[bool]:
+        value = self.selectors.get(key)
+        return None if value is None else value == 'true'
+
+    def min_sdk(self) -> int:
+        return int(self.selectors.get('mintargetsdkversion', '0'))
+
+
+def parse_seapp_contexts(path, partition) -> (List[SeappEntry], List[str]):
+    """Returns (entries, errors) of a seapp_contexts file. Neverallows are skipped."""
+    entries = []
+    errors = []
+    with open(path, 'r', encoding='utf-8') as f:
+        for lineno, line in enumerate(f, 1):
+            line = line.strip()
+            if not line or line.startswith('#') or line.lower().startswith('neverallow'):
+                continue
+            entry = SeappEntry(f"{path}:{lineno}", partition, line)
+            for token in line.split():
+                key, sep, value = token.partition('=')
+                key = key.lower()
+                if not sep:
+                    errors.append(f"{entry.location}: invalid token \"{token}\"")
+                elif key in INPUT_SELECTORS:
+                    # String matching is case-insensitive.
+                    entry.selectors[key] = value.lower()
+                elif key == 'domain':
+                    entry.domain = value
+            entries.append(entry)
+    return entries, errors
+
+
+def strings_overlap(a: Optional[str], b: Optional[str]) -> bool:
+    """Returns whether two user= or name= selectors can match the same string."""
+    if a is None or b is None:
+        return True
+    a_prefix = a.endswith('*')
+    b_prefix = b.endswith('*')
+    if a_prefix and b_prefix:
+        return a[:-1].startswith(b[:-1]) or b[:-1].startswith(a[:-1])
+    if a_prefix:
+        return b.startswith(a[:-1])
+    if b_prefix:
+        return a.startswith(b[:-1])
+    return a == b
+
+
+def overlaps(a: SeappEntry, b: SeappEntry) -> bool:
+    """Returns whether some app matches both entries."""
+    for key in ['user', 'name']:
+        if not strings_overlap(a.selectors.get(key), b.selectors.get(key)):
+            return False
+    if a.selectors.get('seinfo', b.selectors.get('seinfo')) != \
+            b.selectors.get('seinfo', a.selectors.get('seinfo')):
+        return False
+    for key in FALSE_SELECTORS:
+        if bool(a.get_bool(key)) != bool(b.get_bool(key)):
+            return False
+    for key in OPTIONAL_SELECTORS:
+        if None not in (a.get_bool(key), b.get_bool(key)) and a.get_bool(key) != b.get_bool(key):
+            return False
+    return True
+
+
+def string_rank(value: Optional[str]) -> (int, int):
+    """Ranks a string selector: specified before unspecified, fixed before prefix, and longer
+    prefixes before shorter ones."""
+    if value is None:
+        return (0, 0)
+    if value.endswith('*'):
+        return (1, len(value))
+    return (2, 0)
+
+
+def precedence(entry: SeappEntry) -> tuple:
+    """Returns a key which is larger for entries which seapp_context_cmp() puts first."""
+    return (
+        bool(entry.get_bool('issystemserver')),
+        entry.get_bool('isephemeralapp') is not None,
+        string_rank(entry.selectors.get('user')),
+        'seinfo' in entry.selectors,
+        string_rank(entry.selectors.get('name')),
+        entry.get_bool('isprivapp') is not None,
+        entry.min_sdk(),
+        bool(entry.get_bool('fromrunas')),
+        entry.partition in PLATFORM_FILES,
+    )
+
+
+def is_platform_app(entry: SeappEntry, other: SeappEntry, platform_seinfos) -> bool:
+    """Returns whether the apps matched by a platform entry and another entry are platform apps."""
+    if entry.get_bool('issystemserver'):
+        return True
+    name = entry.selectors.get('name')
+    if name is not None and not name.endswith('*'):
+        return True
+    seinfo = entry.selectors.get('seinfo', other.selectors.get('seinfo'))
+    return seinfo in platform_seinfos
+
+
+def analyze(entries: List[SeappEntry], platform_seinfos) -> (List[str], List[str]):
+    """Returns (report lines, errors) for overlapping entries of different partitions."""
+    report = []
+    errors = []
+    for i, a in enumerate(entries):
+        for b in entries[i + 1:]:
+            if a.partition == b.partition or a.domain is None or b.domain is None:
+                continue
+            if a.domain == b.domain or not overlaps(a, b):
+                continue
+
+            pair = f"{a.location} ({a.partition}) \"{a.line}\" and " \
+                   f"{b.location} ({b.partition}) \"{b.line}\""
+            if precedence(a) == precedence(b):
+                errors.append(f"{pair} have identical selectors but different domains")
+                continue
+            winner, loser = (a, b) if precedence(a) > precedence(b) else (b, a)
+            report.append(f"{pair} overlap: {winner.partition} wins with domain={winner.domain}")
+            if winner.partition not in PLATFORM_PARTITIONS and \
+                    loser.partition in PLATFORM_PARTITIONS and \
+                    is_platform_app(loser, winner, platform_seinfos):
+                errors.append(f"{winner.location}: {winner.partition} entry \"{winner.line}\" "
+                              f"overrides {loser.partition} entry \"{loser.line}\" "
+                              f"({loser.location}) for a platform app")
+    return report, errors
+
+
+def do_main(argv):
+    parser = argparse.ArgumentParser()
+    parser.add_argument('-f', '--file', action='append', required=True,
+                        help='<partition>=<seapp_contexts>')
+    parser.add_argument('--platform-seinfo', action='append', default=[],
+                        help='seinfo of platform apps (default: platform)')
+    parser.add_argument('-o', '--output', required=True, help='report of overlapping entries')
+    args = parser.parse_args(argv)
+
+    entries = []
+    errors = []
+    for arg in args.file:
+        partition, sep, path = arg.partition('=')
+        if not sep or partition not in PARTITIONS:
+            sys.exit(f"Error: {arg} must have the form <partition>=<seapp_contexts>")
+        file_entries, file_errors = parse_seapp_contexts(path, partition)
+        entries.extend(file_entries)
+        errors.extend(file_errors)
+
+    report, analysis_errors = analyze(entries, set(args.platform_seinfo or ['platform']))
+    errors.extend(analysis_errors)
+    with open(args.output, 'w', encoding='utf-8') as f:
+        f.writelines(line + '\n' for line in report + errors)
+
+    if errors:
+        sys.exit('seapp_contexts entries of different partitions conflict:\n' + '\n'.join(errors))
+
+
+if __name__ == '__main__':
+    do_main(sys.argv[1:])
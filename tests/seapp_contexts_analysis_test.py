@@ -0,0 +1,94 @@
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Tests for seapp_contexts_analysis"""
+
+import os
+import shutil
+import tempfile
+import unittest
+
+import seapp_contexts_analysis as analysis
+
+
+PLATFORM = """
+neverallow name=foo domain=bar
+user=_app seinfo=platform domain=platform_app type=app_data_file levelFrom=user
+user=_app seinfo=media domain=mediaprovider type=app_data_file levelFrom=user
+user=_app domain=untrusted_app type=app_data_file levelFrom=all
+user=system seinfo=platform domain=system_app type=system_app_data_file
+"""
+
+VENDOR = """
+user=_app seinfo=platform name=com.android.foo domain=vendor_foo_app type=app_data_file
+user=_app seinfo=vendor name=com.acme.* domain=vendor_app type=app_data_file
+user=_app seinfo=media domain=vendor_media type=app_data_file
+user=system seinfo=platform domain=system_app type=system_app_data_file
+"""
+
+PRODUCT = """
+user=_app seinfo=media domain=product_media type=app_data_file
+"""
+
+
+# pylint: disable=missing-docstring
+class SeappContextsAnalysisTest(unittest.TestCase):
+
+    def setUp(self):
+        self.temp_dir = tempfile.mkdtemp()
+
+    def tearDown(self):
+        shutil.rmtree(self.temp_dir)
+
+    def parse(self, partition, content):
+        path = os.path.join(self.temp_dir, partition)
+        with open(path, 'w', encoding='utf-8') as f:
+            f.write(content)
+        entries, errors = analysis.parse_seapp_contexts(path, partition)
+        self.assertEqual(errors, [])
+        return entries
+
+    def test_overlaps(self):
+        def entry(line):
+            e = analysis.SeappEntry('', 'system', line)
+            for token in line.split():
+                key, _, value = token.partition('=')
+                e.selectors[key.lower()] = value.lower()
+            return e
+        self.assertTrue(analysis.overlaps(entry('user=_app'), entry('user=_app name=com.foo')))
+        self.assertTrue(analysis.overlaps(entry('name=com.*'), entry('name=com.foo.*')))
+        self.assertFalse(analysis.overlaps(entry('name=com.bar*'), entry('name=com.foo')))
+        self.assertFalse(analysis.overlaps(entry('seinfo=a'), entry('seinfo=b')))
+        self.assertFalse(analysis.overlaps(entry('isSystemServer=true'), entry('user=system')))
+        self.assertTrue(analysis.overlaps(entry('isPrivApp=true'), entry('user=_app')))
+
+    def test_analyze(self):
+        entries = self.parse('system', PLATFORM) + self.parse('vendor', VENDOR)
+        report, errors = analysis.analyze(entries, {'platform'})
+        # vendor_foo_app overrides both platform_app and untrusted_app for com.android.foo.
+        self.assertEqual(len(errors), 2)
+        for error in errors:
+            self.assertIn('vendor entry "user=_app seinfo=platform name=com.android.foo', error)
+        self.assertEqual(len(report), 5)
+        self.assertTrue(any('vendor wins with domain=vendor_app' in r for r in report))
+        self.assertTrue(any('system wins with domain=mediaprovider' in r for r in report))
+
+    def test_identical(self):
+        entries = self.parse('system', PLATFORM) + self.parse('product', PRODUCT)
+        _, errors = analysis.analyze(entries, {'platform'})
+        self.assertEqual(len(errors), 1)
+        self.assertIn('identical selectors', errors[0])
+
+
+if __name__ == '__main__':
+    unittest.main(verbosity=2)
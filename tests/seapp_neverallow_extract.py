@@ -0,0 +1,141 @@
+#!/usr/bin/env python3
+#
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Extracts neverallow statements of seapp_contexts files for checkseapp.
+
+Usage:
+    $ m4 -s plat_seapp_contexts > seapp_contexts.m4out
+    $ seapp_neverallow_extract -o neverallow seapp_contexts.m4out
+
+Each statement is one line, or several lines joined by a trailing "\\". A
+statement starting with "neverallow" (case-insensitive) must be followed by
+key=value selectors known to checkseapp, and is written as a single line.
+Anything else which can't be a seapp_contexts statement, like a policy
+neverallow or a neverallow preceded by whitespace, is an error rather than
+being dropped. Locations of errors follow the "#line" directives of m4 -s.
+"""
+
+import argparse
+import re
+import sys
+from dataclasses import dataclass
+from typing import List
+
+# Keys of seapp_contexts entries, as known to checkseapp.
+SEAPP_KEYS = {key.lower() for key in [
+    'isSystemServer', 'isEphemeralApp', 'user', 'seinfo', 'name', 'isPrivApp',
+    'minTargetSdkVersion', 'fromRunAs', 'isIsolatedComputeApp', 'isSdkSandboxAudit',
+    'isSdkSandboxNext', 'domain', 'type', 'levelFromUid', 'levelFrom', 'level',
+]}
+
+SYNCLINE_PATTERN = re.compile(r'#line (\d+)(?: "(.*)")?')
+
+
+@dataclass
+class Statement:
+    """A statement of a seapp_contexts file, with continuation lines joined."""
+    location: str
+    text: str
+
+
+def read_statements(path) -> (List[Statement], List[str]):
+    """Returns (statements, errors) of a seapp_contexts file, with comments skipped."""
+    statements = []
+    errors = []
+    filename = path
+    lineno = 0
+    pending = None
+    with open(path, 'r', encoding='utf-8') as f:
+        for line in f:
+            lineno += 1
+            line = line.rstrip('\n')
+            sync = SYNCLINE_PATTERN.fullmatch(line)
+            if sync:
+                if pending:
+                    errors.append(f"{pending.location}: unterminated continuation")
+                    pending = None
+                # The line following the directive has the given number.
+                lineno = int(sync.group(1)) - 1
+                filename = sync.group(2) or filename
+                continue
+
+            if pending is None:
+                if not line.strip() or line.lstrip().startswith('#'):
+                    continue
+                pending = Statement(f"{filename}:{lineno}", '')
+            continued = line.endswith('\\')
+            pending.text += (line[:-1] if continued else line) + ' '
+            if not continued:
+                pending.text = pending.text.rstrip()
+                statements.append(pending)
+                pending = None
+    if pending:
+        errors.append(f"{pending.location}: unterminated continuation")
+    return statements, errors
+
+
+def parse_neverallow(statement: Statement) -> (str, List[str]):
+    """Returns (normalized neverallow or None, errors) of a statement."""
+    text = statement.text
+    if text[:1].isspace():
+        if text.split()[0].lower() == 'neverallow':
+            return None, [f"{statement.location}: whitespace before neverallow"]
+        return None, []
+    tokens = text.split()
+    if tokens[0].lower() != 'neverallow':
+        return None, []
+
+    errors = []
+    if len(tokens) == 1:
+        errors.append(f"{statement.location}: neverallow without selectors")
+    for token in tokens[1:]:
+        key, sep, _ = token.partition('=')
+        if not sep:
+            errors.append(f"{statement.location}: \"{token}\" isn't a key=value selector; "
+                          f"is this a policy neverallow?")
+            break
+        if key.lower() not in SEAPP_KEYS:
+            errors.append(f"{statement.location}: unknown seapp_contexts key \"{key}\"")
+    if errors:
+        return None, errors
+    return 'neverallow ' + ' '.join(tokens[1:]), []
+
+
+def do_main(argv):
+    parser = argparse.ArgumentParser()
+    parser.add_argument('-o', '--output', required=True)
+    parser.add_argument('input', nargs='+', help='m4-processed seapp_contexts files')
+    args = parser.parse_args(argv)
+
+    neverallows = []
+    errors = []
+    for path in args.input:
+        statements, read_errors = read_statements(path)
+        errors.extend(read_errors)
+        for statement in statements:
+            neverallow, parse_errors = parse_neverallow(statement)
+            errors.extend(parse_errors)
+            if neverallow:
+                neverallows.append(neverallow)
+
+    if errors:
+        sys.exit('Failed to extract seapp_contexts neverallows:\n' + '\n'.join(errors))
+
+    with open(args.output, 'w', encoding='utf-8') as f:
+        f.writelines(line + '\n' for line in neverallows)
+
+
+if __name__ == '__main__':
+    do_main(sys.argv[1:])
@@ -0,0 +1,84 @@
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Tests for seapp_neverallow_extract"""
+
+import os
+import shutil
+import tempfile
+import unittest
+
+import seapp_neverallow_extract as extract
+
+
+SEAPP_CONTEXTS = """#line 1 "private/seapp_contexts"
+# only the system server can be assigned the system_server domains
+neverallow isSystemServer=false domain=system_server
+NeverAllow user=((?!system).)* \\
+    domain=system_app
+user=system seinfo=platform domain=system_app type=system_app_data_file
+
+#line 40 "vendor/seapp_contexts"
+neverallow user=shell \\
+           name=((?!com\\.android\\.shell).)*
+"""
+
+
+# pylint: disable=missing-docstring
+class SeappNeverallowExtractTest(unittest.TestCase):
+
+    def setUp(self):
+        self.temp_dir = tempfile.mkdtemp()
+
+    def tearDown(self):
+        shutil.rmtree(self.temp_dir)
+
+    def statements(self, content):
+        path = os.path.join(self.temp_dir, 'seapp_contexts.m4out')
+        with open(path, 'w', encoding='utf-8') as f:
+            f.write(content)
+        return extract.read_statements(path)
+
+    def test_extract(self):
+        statements, errors = self.statements(SEAPP_CONTEXTS)
+        self.assertEqual(errors, [])
+        self.assertEqual([s.location for s in statements], [
+            'private/seapp_contexts:2',
+            'private/seapp_contexts:3',
+            'private/seapp_contexts:5',
+            'vendor/seapp_contexts:40',
+        ])
+        self.assertEqual([extract.parse_neverallow(s)[0] for s in statements], [
+            'neverallow isSystemServer=false domain=system_server',
+            'neverallow user=((?!system).)* domain=system_app',
+            None,
+            r'neverallow user=shell name=((?!com\.android\.shell).)*',
+        ])
+
+    def test_errors(self):
+        statements, errors = self.statements(
+            'neverallow domain system_file:file write;\n'
+            '  neverallow user=foo domain=bar\n'
+            'neverallow usr=foo\n'
+            'neverallow user=foo \\\n')
+        self.assertEqual(len(errors), 1)
+        self.assertIn('unterminated continuation', errors[0])
+        errors = [e for s in statements for e in extract.parse_neverallow(s)[1]]
+        self.assertEqual(len(errors), 3)
+        self.assertIn('policy neverallow', errors[0])
+        self.assertIn('whitespace before neverallow', errors[1])
+        self.assertIn('unknown seapp_contexts key "usr"', errors[2])
+
+
+if __name__ == '__main__':
+    unittest.main(verbosity=2)
@@ -259,14 +259,14 @@ struct avtab_iter {
 };
 
 /*
- * print allow rule into *out buffer.
+ * print avtab rules of the given kind (AVTAB_ALLOWED or AVTAB_TRANSITION) into *out buffer.
  *
  * Returns -1 on error.
  * Returns 0 on successfully reading an avtab entry.
  * Returns 1 on complete
  */
-static int get_avtab_allow_rule(char *out, size_t max_size, policydb_t *db,
-                                 struct avtab_iter *avtab_i)
+static int get_avtab_rule(char *out, size_t max_size, policydb_t *db,
+                          struct avtab_iter *avtab_i, uint16_t specified)
 {
     size_t len;
 
@@ -275,18 +275,26 @@ static int get_avtab_allow_rule(char *out, size_t max_size, policydb_t *db,
             avtab_i->cur = avtab_i->avtab->htable[avtab_i->i];
         }
         for (; avtab_i->cur; avtab_i->cur = (avtab_i->cur)->next) {
-            if (!((avtab_i->cur)->key.specified & AVTAB_ALLOWED)) continue;
-
-            len = snprintf(out, max_size, "allow,%s,%s,%s,%s",
-                    db->p_type_val_to_name[(avtab_i->cur)->key.source_type - 1],
-                    db->p_type_val_to_name[(avtab_i->cur)->key.target_type - 1],
-                    db->p_class_val_to_name[(avtab_i->cur)->key.target_class - 1],
-                    sepol_av_to_string(db, (avtab_i->cur)->key.target_class, (avtab_i->cur)->datum.data));
+            if (!((avtab_i->cur)->key.specified & specified)) continue;
+
+            if (specified == AVTAB_TRANSITION) {
+                len = snprintf(out, max_size, "type_transition,%s,%s,%s,%s",
+                        db->p_type_val_to_name[(avtab_i->cur)->key.source_type - 1],
+                        db->p_type_val_to_name[(avtab_i->cur)->key.target_type - 1],
+                        db->p_class_val_to_name[(avtab_i->cur)->key.target_class - 1],
+                        db->p_type_val_to_name[(avtab_i->cur)->datum.data - 1]);
+            } else {
+                len = snprintf(out, max_size, "allow,%s,%s,%s,%s",
+                        db->p_type_val_to_name[(avtab_i->cur)->key.source_type - 1],
+                        db->p_type_val_to_name[(avtab_i->cur)->key.target_type - 1],
+                        db->p_class_val_to_name[(avtab_i->cur)->key.target_class - 1],
+                        sepol_av_to_string(db, (avtab_i->cur)->key.target_class, (avtab_i->cur)->datum.data));
+            }
             avtab_i->cur = (avtab_i->cur)->next;
             if (!(avtab_i->cur))
                 (avtab_i->i)++;
             if (len >= max_size) {
-                std::cerr << "Allow rule exceeds buffer size." << std::endl;
+                std::cerr << "Rule exceeds buffer size." << std::endl;
                 return -1;
             }
             return 0;
@@ -302,7 +310,15 @@ int get_allow_rule(char *out, size_t len, void *policydbp, void *avtab_iterp)
     policydb_t *db = static_cast<policydb_t *>(policydbp);
     struct avtab_iter *avtab_i = static_cast<struct avtab_iter *>(avtab_iterp);
 
-    return get_avtab_allow_rule(out, len, db, avtab_i);
+    return get_avtab_rule(out, len, db, avtab_i, AVTAB_ALLOWED);
+}
+
+int get_type_transition_rule(char *out, size_t len, void *policydbp, void *avtab_iterp)
+{
+    policydb_t *db = static_cast<policydb_t *>(policydbp);
+    struct avtab_iter *avtab_i = static_cast<struct avtab_iter *>(avtab_iterp);
+
+    return get_avtab_rule(out, len, db, avtab_i, AVTAB_TRANSITION);
 }
 
 static avtab_iter *init_avtab_common(avtab_t *in)
@@ -11,46 +11,165 @@
 # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
 # See the License for the specific language governing permissions and
 # limitations under the License.
+"""Checks that frozen public policy doesn't change.
 
-from optparse import OptionParser
-import mini_parser
-import os
+Usage:
+    $ sepolicy_freeze_test -c base_plat_pub_policy.cil -p 202404_plat_pub_policy.cil \\
+        -d system_ext/public:prebuilts/api/202404/system_ext/public:bug_map \\
+        -e freeze_test_exceptions.txt -o freeze_report.txt
+
+The plat public CIL must not remove types or attributes. Each directory pair
+(-d <current>:<prebuilt>[:<exclude pattern>,...]) must have the same files with
+the same content, like `diff -r -q`. The report lists every added, removed and
+changed type, attribute, macro, contexts entry and statement with its file and
+line, whether it fails the test or not, to explain the differences.
+
+Deltas listed in the exceptions file (-e) are allowed. A file of a directory
+pair which differs is allowed only if every delta found in it is. Exceptions
+which match no delta are reported, so that they can be removed.
+"""
+
+import argparse
 import sys
 
-def do_main():
-    usage = "sepolicy_freeze_test "
-    usage += "-c current_cil -p prebuilt_cil [--help]"
-    parser = OptionParser(usage=usage)
-    parser.add_option("-c", "--current", dest="current", metavar="FILE")
-    parser.add_option("-p", "--prebuilt", dest="prebuilt", metavar="FILE")
+import freeze_report
+
+
+class Section:
+    """Differences between one pair of current and prebuilt policy."""
+
+    def __init__(self, title, deltas, is_violation, exceptions):
+        self.title = title
+        self.deltas = deltas
+        self.allowed = {}
+        self.violations = []
+        for delta in deltas:
+            if not is_violation(delta):
+                continue
+            exception = exceptions.get(delta.describe())
+            if exception:
+                self.allowed[delta.describe()] = exception
+            else:
+                self.violations.append(delta)
+
+    def failures(self):
+        return [str(delta) for delta in self.violations]
+
+    def lines(self):
+        result = [f"== {self.title}: {len(self.deltas)} differences, "
+                  f"{len(self.violations)} not allowed"]
+        result.extend(self.delta_lines())
+        return result
+
+    def delta_lines(self):
+        result = []
+        for delta in self.deltas:
+            exception = self.allowed.get(delta.describe())
+            if exception:
+                result.append(f"allowed by b/{exception.bug}: {delta}")
+            elif delta in self.violations:
+                result.append(f"ERROR: {delta}")
+            else:
+                result.append(f"info: {delta}")
+        return result
+
+
+class DirSection(Section):
+    """Differences between a pair of current and prebuilt directories.
+
+    Files which differ fail the test. Deltas only explain the differences, as
+    they don't cover everything, e.g. a changed file with no delta still fails.
+    """
+
+    def __init__(self, title, deltas, file_diffs, exceptions):
+        super().__init__(title, deltas, lambda delta: True, exceptions)
+        self.file_diffs = file_diffs
+        self.file_violations = []
+        for diff in file_diffs:
+            explained_by = [d for d in deltas if diff.explains(d)]
+            if not explained_by or any(d in self.violations for d in explained_by):
+                self.file_violations.append(diff)
+
+    def failures(self):
+        return [str(diff) for diff in self.file_violations]
+
+    def lines(self):
+        result = [f"== {self.title}: {len(self.file_diffs)} files differ, "
+                  f"{len(self.file_violations)} not allowed, {len(self.deltas)} differences"]
+        for diff in self.file_diffs:
+            marker = 'ERROR' if diff in self.file_violations else 'allowed'
+            result.append(f"{marker}: {diff}")
+        result.extend(self.delta_lines())
+        return result
+
+
+def is_removed_declaration(delta):
+    return delta.change == 'removed' and delta.category in ('type', 'attribute')
+
+
+def parse_pair(arg):
+    current, sep, rest = arg.partition(':')
+    prebuilt, _, excludes = rest.partition(':')
+    if not sep or not current or not prebuilt:
+        sys.exit(f"Error: {arg} must have the form <current>:<prebuilt>[:<exclude>,...]")
+    return current, prebuilt, [e for e in excludes.split(',') if e]
+
+
+def do_main(argv):
+    parser = argparse.ArgumentParser()
+    parser.add_argument('-c', '--current', required=True, help='current plat public CIL')
+    parser.add_argument('-p', '--prebuilt', required=True, help='prebuilt plat public CIL')
+    parser.add_argument('-d', '--dir', action='append', default=[],
+                        help='<current>:<prebuilt>[:<exclude>,...] directories')
+    parser.add_argument('-e', '--exceptions', help='deltas allowed after the freeze')
+    parser.add_argument('-o', '--output', help='freeze report')
+    args = parser.parse_args(argv)
+
+    exceptions = {}
+    if args.exceptions:
+        exception_list, errors = freeze_report.parse_exceptions(args.exceptions)
+        if errors:
+            sys.exit('\n'.join(errors))
+        exceptions = {e.delta: e for e in exception_list}
 
-    (options, args) = parser.parse_args()
+    sections = [Section(
+        f"plat public policy {args.current} vs {args.prebuilt}",
+        freeze_report.compare(freeze_report.parse_cil(args.current),
+                              freeze_report.parse_cil(args.prebuilt)),
+        is_removed_declaration, exceptions)]
 
-    if not options.current or not options.prebuilt:
-        sys.exit("Must specify both current and prebuilt\n" + parser.usage)
-    if not os.path.exists(options.current):
-        sys.exit("Current policy " + options.current + " does not exist\n"
-                + parser.usage)
-    if not os.path.exists(options.prebuilt):
-        sys.exit("Prebuilt policy " + options.prebuilt + " does not exist\n"
-                + parser.usage)
+    for arg in args.dir:
+        current, prebuilt, excludes = parse_pair(arg)
+        sections.append(DirSection(
+            f"{current} vs {prebuilt}",
+            freeze_report.compare(freeze_report.parse_dir(current, excludes),
+                                  freeze_report.parse_dir(prebuilt, excludes)),
+            freeze_report.diff_files(current, prebuilt, excludes), exceptions))
 
-    current_policy = mini_parser.MiniCilParser(options.current)
-    prebuilt_policy = mini_parser.MiniCilParser(options.prebuilt)
+    used = {delta for section in sections for delta in section.allowed}
+    stale = [e for delta, e in exceptions.items() if delta not in used]
 
-    results = ""
-    removed_types = prebuilt_policy.types - current_policy.types
-    removed_attributes = prebuilt_policy.typeattributes - current_policy.typeattributes
-    removed_attributes = set(filter(lambda x: "base_typeattr_" not in x, removed_attributes))
+    report = [line for section in sections for line in section.lines()]
+    if stale:
+        report.append(f"== {len(stale)} exceptions match no delta and can be removed")
+        report.extend(f"stale: {e}" for e in stale)
+    if args.output:
+        with open(args.output, 'w', encoding='utf-8') as f:
+            f.writelines(line + '\n' for line in report)
 
-    if removed_types:
-        results += "The following public types were removed:\n" + ", ".join(removed_types) + "\n"
+    for exception in stale:
+        print(f"Warning: exception matches no delta: {exception}", file=sys.stderr)
 
-    if removed_attributes:
-        results += "The following public attributes were removed:\n" + ", ".join(removed_attributes) + "\n"
+    failed = [s for s in sections if s.failures()]
+    if failed:
+        summary = ['Public policy changed after it was frozen:']
+        for section in failed:
+            summary.append(f"{section.title}:")
+            summary.extend(f"  {failure}" for failure in section.failures())
+        if args.output:
+            summary.append(f"See {args.output} for all differences.")
+        sys.exit('\n'.join(summary))
 
-    if len(results) > 0:
-        sys.exit(results)
 
 if __name__ == '__main__':
-    do_main()
+    do_main(sys.argv[1:])
@@ -0,0 +1,201 @@
+#!/usr/bin/env python3
+#
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Generates property_contexts entries from sysprop_library API files.
+
+Usage:
+    $ sysprop_contexts_gen --api AdbProperties-current.txt \\
+        --label android.sysprop.AdbProperties=adbd_config_prop \\
+        --label persist.foo.=foo_prop -o generated_property_contexts
+
+Each --label maps a key to a property type. The key is either the name of a
+sysprop module (the "module" field of an API file), or a property name prefix
+ending with ".". Every property of the APIs which a key matches gets an exact
+entry with its declared type (and enum values). Prefix keys also get a prefix
+entry, so that properties under the namespace which aren't in any API get the
+same label. A property may only be matched by keys mapping to the same label.
+"""
+
+import argparse
+import sys
+from dataclasses import dataclass
+from typing import List
+
+# Types of sysprop, and the matching property_contexts types. Lists are strings.
+SYSPROP_TYPES = {
+    'Boolean': 'bool',
+    'Integer': 'int',
+    'Long': 'int',
+    'UInt': 'uint',
+    'ULong': 'uint',
+    'Double': 'double',
+    'String': 'string',
+    'Enum': 'enum',
+}
+
+
+@dataclass
+class SyspropProperty:
+    """A property declared by a sysprop API file."""
+    location: str
+    module: str
+    name: str
+    prop_type: str
+    enum_values: List[str]
+
+
+def tokenize(text):
+    """Yields tokens of a text proto: names, strings, ':', '{' and '}'."""
+    i = 0
+    while i < len(text):
+        c = text[i]
+        if c.isspace():
+            i += 1
+        elif c == '#':
+            while i < len(text) and text[i] != '\n':
+                i += 1
+        elif c in ':{}':
+            yield c
+            i += 1
+        elif c == '"':
+            value = []
+            i += 1
+            while i < len(text) and text[i] != '"':
+                if text[i] == '\\' and i + 1 < len(text):
+                    i += 1
+                value.append(text[i])
+                i += 1
+            i += 1
+            yield '"' + ''.join(value)
+        else:
+            start = i
+            while i < len(text) and not text[i].isspace() and text[i] not in ':{}"#':
+                i += 1
+            yield text[start:i]
+
+
+def parse_message(tokens):
+    """Parses fields of a text proto message into a list of (name, value) pairs.
+
+    Nested messages are lists of pairs themselves, and strings are unquoted.
+    """
+    fields = []
+    for token in tokens:
+        if token == '}':
+            return fields
+        name = token
+        token = next(tokens)
+        if token == ':':
+            token = next(tokens)
+        if token == '{':
+            fields.append((name, parse_message(tokens)))
+        else:
+            fields.append((name, token[1:] if token.startswith('"') else token))
+    return fields
+
+
+def field_value(fields, name, default=None):
+    for field_name, value in fields:
+        if field_name == name:
+            return value
+    return default
+
+
+def parse_api_file(path) -> List[SyspropProperty]:
+    """Returns properties declared by a sysprop API file, e.g. FooProperties-current.txt."""
+    with open(path, 'r', encoding='utf-8') as f:
+        fields = parse_message(iter(tokenize(f.read())))
+
+    props = []
+    for name, value in fields:
+        if name != 'props':
+            continue
+        module = field_value(value, 'module', '')
+        for prop_field, prop in value:
+            if prop_field != 'prop':
+                continue
+            # Enum fields are omitted from text protos when they have the default value.
+            sysprop_type = field_value(prop, 'type', 'Boolean')
+            prop_type = SYSPROP_TYPES.get(sysprop_type, 'string')
+            enum_values = []
+            if prop_type == 'enum':
+                enum_values = field_value(prop, 'enum_values', '').split('|')
+            props.append(SyspropProperty(path, module, field_value(prop, 'prop_name', ''),
+                                         prop_type, enum_values))
+    return props
+
+
+def generate(props: List[SyspropProperty], labels) -> (List[str], List[str]):
+    """Returns (property_contexts lines, errors).
+
+    labels is a list of (key, label) pairs, in the order they were given.
+    """
+    lines = []
+    errors = []
+    generated = {}
+    for key, label in labels:
+        context = f"u:object_r:{label}:s0"
+        is_prefix = key.endswith('.')
+        if is_prefix:
+            lines.append(f"{key} {context} prefix string")
+
+        matched = False
+        for prop in props:
+            if (prop.name.startswith(key) if is_prefix else prop.module == key):
+                matched = True
+                if prop.name in generated:
+                    if generated[prop.name] != context:
+                        errors.append(f"{prop.name} ({prop.location}) is mapped to both "
+                                      f"{generated[prop.name]} and {context}")
+                    continue
+                generated[prop.name] = context
+                entry = f"{prop.name} {context} exact {prop.prop_type}"
+                if prop.enum_values:
+                    entry += ' ' + ' '.join(prop.enum_values)
+                lines.append(entry)
+        if not matched:
+            errors.append(f"{key}={label} doesn't match any sysprop property")
+    return lines, errors
+
+
+def do_main(argv):
+    parser = argparse.ArgumentParser()
+    parser.add_argument('--api', action='append', default=[], help='sysprop API file')
+    parser.add_argument('--label', action='append', required=True, help='<key>=<label>')
+    parser.add_argument('-o', '--output', required=True)
+    args = parser.parse_args(argv)
+
+    props = []
+    for api in args.api:
+        props.extend(parse_api_file(api))
+
+    labels = []
+    for arg in args.label:
+        key, sep, label = arg.partition('=')
+        if not sep or not key or not label:
+            sys.exit(f"Error: {arg} must have the form <key>=<label>")
+        labels.append((key, label))
+
+    lines, errors = generate(props, labels)
+    if errors:
+        sys.exit('\n'.join(errors))
+
+    with open(args.output, 'w', encoding='utf-8') as f:
+        f.write('# Generated from sysprop_library API files. Do not edit.\n')
+        f.writelines(line + '\n' for line in lines)
+
+
+if __name__ == '__main__':
+    do_main(sys.argv[1:])
@@ -0,0 +1,102 @@
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Tests for sysprop_contexts_gen"""
+
+import os
+import shutil
+import tempfile
+import unittest
+
+import sysprop_contexts_gen as gen
+
+
+API = """
+props {
+  module: "android.sysprop.FooProperties"
+  prop {
+    api_name: "enabled"
+    scope: Public
+    prop_name: "ro.foo.enabled"
+  }
+  prop {
+    api_name: "mode"
+    type: Enum
+    access: ReadWrite
+    prop_name: "persist.foo.mode"
+    enum_values: "fast|slow"
+  }
+  prop {
+    api_name: "names"
+    type: StringList
+    prop_name: "persist.foo.names"
+  }
+}
+props {
+  module: "android.sysprop.BarProperties"
+  prop {
+    api_name: "count"
+    type: UInt
+    prop_name: "ro.bar.count"
+  }
+}
+"""
+
+
+# pylint: disable=missing-docstring
+class SyspropContextsGenTest(unittest.TestCase):
+
+    def setUp(self):
+        self.temp_dir = tempfile.mkdtemp()
+        path = os.path.join(self.temp_dir, 'current.txt')
+        with open(path, 'w', encoding='utf-8') as f:
+            f.write(API)
+        self.props = gen.parse_api_file(path)
+
+    def tearDown(self):
+        shutil.rmtree(self.temp_dir)
+
+    def test_parse(self):
+        self.assertEqual([(p.module, p.name, p.prop_type, p.enum_values) for p in self.props], [
+            ('android.sysprop.FooProperties', 'ro.foo.enabled', 'bool', []),
+            ('android.sysprop.FooProperties', 'persist.foo.mode', 'enum', ['fast', 'slow']),
+            ('android.sysprop.FooProperties', 'persist.foo.names', 'string', []),
+            ('android.sysprop.BarProperties', 'ro.bar.count', 'uint', []),
+        ])
+
+    def test_generate(self):
+        lines, errors = gen.generate(self.props, [
+            ('persist.foo.', 'foo_config_prop'),
+            ('android.sysprop.BarProperties', 'bar_prop'),
+        ])
+        self.assertEqual(errors, [])
+        self.assertEqual(lines, [
+            'persist.foo. u:object_r:foo_config_prop:s0 prefix string',
+            'persist.foo.mode u:object_r:foo_config_prop:s0 exact enum fast slow',
+            'persist.foo.names u:object_r:foo_config_prop:s0 exact string',
+            'ro.bar.count u:object_r:bar_prop:s0 exact uint',
+        ])
+
+    def test_errors(self):
+        _, errors = gen.generate(self.props, [
+            ('ro.foo.', 'foo_prop'),
+            ('android.sysprop.FooProperties', 'other_prop'),
+            ('android.sysprop.BazProperties', 'baz_prop'),
+        ])
+        self.assertEqual(len(errors), 2)
+        self.assertIn('ro.foo.enabled', errors[0])
+        self.assertIn("BazProperties=baz_prop doesn't match", errors[1])
+
+
+if __name__ == '__main__':
+    unittest.main(verbosity=2)
@@ -0,0 +1,224 @@
+#!/usr/bin/env python3
+#
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Checks VINTF manifest fragments against service_contexts and hwservice_contexts.
+
+Usage:
+    $ vintf_contexts_check -m manifest_foo.xml -m manifest_bar.xml \\
+        --aidl-interfaces stable_aidl_interfaces.txt \\
+        -s plat_service_contexts -s vendor_service_contexts \\
+        -w plat_hwservice_contexts -w vendor_hwservice_contexts \\
+        --stale-check vendor_service_contexts --stale-check vendor_hwservice_contexts
+
+Every instance declared by a manifest must be labeled:
+  - an AIDL instance "package.IFoo/instance" needs a service_contexts entry.
+  - a HIDL interface "package::IFoo" served over hwbinder needs a
+    hwservice_contexts entry.
+The "*" catch-all doesn't count as a label.
+
+Every entry of the files given with --stale-check must belong to something
+declared:
+  - a service_contexts entry of a stable AIDL interface, i.e. one listed by
+    --aidl-interfaces or named like "package.IFoo/instance", must name a
+    declared AIDL instance. Other services aren't HALs and are skipped.
+  - a hwservice_contexts entry must name a declared HIDL interface.
+"""
+
+import argparse
+import re
+import sys
+import xml.etree.ElementTree as ET
+from dataclasses import dataclass
+from typing import Dict, List
+
+# Service names which look like a stable AIDL HAL instance.
+AIDL_INSTANCE_PATTERN = re.compile(r'[a-z][a-z0-9_]*(\.[a-z0-9_]+)*\.I[A-Z]\w*/.+')
+
+
+@dataclass
+class ContextsEntry:
+    """A single service_contexts or hwservice_contexts entry."""
+    location: str
+    name: str
+    context: str
+
+
+def parse_contexts(path) -> (List[ContextsEntry], List[str]):
+    """Returns (entries, errors) of a service_contexts or hwservice_contexts file."""
+    entries = []
+    errors = []
+    with open(path, 'r', encoding='utf-8') as f:
+        for lineno, line in enumerate(f, 1):
+            line = line.strip()
+            if not line or line.startswith('#'):
+                continue
+            location = f"{path}:{lineno}"
+            split = line.split()
+            if len(split) != 2:
+                errors.append(f"{location}: invalid contexts entry \"{line}\"")
+                continue
+            entries.append(ContextsEntry(location, split[0], split[1]))
+    return entries, errors
+
+
+def parse_manifest(path) -> (Dict[str, str], Dict[str, str], List[str]):
+    """Returns (AIDL instances, HIDL interfaces, errors) declared by a manifest fragment.
+
+    AIDL instances are "package.IFoo/instance", and HIDL interfaces
+    "package::IFoo". Both map to the location which declares them.
+    """
+    aidl = {}
+    hidl = {}
+    errors = []
+    try:
+        root = ET.parse(path).getroot()
+    except ET.ParseError as e:
+        return aidl, hidl, [f"{path}: {e}"]
+
+    for hal in root.iter('hal'):
+        hal_format = hal.get('format', 'hidl')
+        if hal_format not in ('aidl', 'hidl'):
+            continue
+        package = hal.findtext('name', '').strip()
+        location = f"{path}: {package}"
+        if not package:
+            errors.append(f"{path}: <hal> without <name>")
+            continue
+        # Passthrough HALs are never registered to hwservicemanager.
+        if hal_format == 'hidl' and hal.findtext('transport', '').strip() == 'passthrough':
+            continue
+
+        interfaces = []
+        for interface in hal.findall('interface'):
+            name = interface.findtext('name', '').strip()
+            for instance in interface.findall('instance'):
+                interfaces.append((name, (instance.text or '').strip()))
+        for fqname in hal.findall('fqname'):
+            # AIDL: "IFoo/instance", HIDL: "@1.0::IFoo/instance"
+            name, _, instance = (fqname.text or '').strip().rpartition('::')[2].partition('/')
+            interfaces.append((name, instance))
+
+        for name, instance in interfaces:
+            if not name or not instance:
+                errors.append(f"{location}: invalid interface {name}/{instance}")
+            elif hal_format == 'aidl':
+                aidl.setdefault(f"{package}.{name}/{instance}", location)
+            else:
+                hidl.setdefault(f"{package}::{name}", location)
+    return aidl, hidl, errors
+
+
+def read_aidl_interfaces(path) -> List[str]:
+    """Returns stable AIDL interface names listed by a file, e.g. android.hardware.foo.IFoo."""
+    with open(path, 'r', encoding='utf-8') as f:
+        return [line.strip() for line in f if line.strip() and not line.startswith('#')]
+
+
+def labeled(entries: List[ContextsEntry]):
+    return {e.name for e in entries if e.name != '*'}
+
+
+def check_missing(aidl, hidl, service_entries, hwservice_entries) -> List[str]:
+    """Returns errors for declared instances without a label."""
+    errors = []
+    services = labeled(service_entries)
+    for name, location in sorted(aidl.items()):
+        if name not in services:
+            errors.append(f"{location}: AIDL instance {name} has no service_contexts entry")
+    hwservices = labeled(hwservice_entries)
+    for name, location in sorted(hidl.items()):
+        if name not in hwservices:
+            errors.append(f"{location}: HIDL interface {name} has no hwservice_contexts entry")
+    return errors
+
+
+def check_stale_services(entries: List[ContextsEntry], aidl, aidl_interfaces) -> List[str]:
+    """Returns errors for service_contexts entries of undeclared AIDL instances."""
+    errors = []
+    for entry in entries:
+        interface = entry.name.partition('/')[0]
+        if interface not in aidl_interfaces and not AIDL_INSTANCE_PATTERN.fullmatch(entry.name):
+            continue
+        if entry.name not in aidl:
+            errors.append(f"{entry.location}: {entry.name} isn't declared by any VINTF manifest")
+    return errors
+
+
+def check_stale_hwservices(entries: List[ContextsEntry], hidl) -> List[str]:
+    """Returns errors for hwservice_contexts entries of undeclared HIDL interfaces."""
+    return [f"{e.location}: {e.name} isn't declared by any VINTF manifest"
+            for e in entries if e.name != '*' and e.name not in hidl]
+
+
+def load_contexts(paths, errors) -> Dict[str, List[ContextsEntry]]:
+    result = {}
+    for path in paths:
+        entries, file_errors = parse_contexts(path)
+        result[path] = entries
+        errors.extend(file_errors)
+    return result
+
+
+def do_main(argv):
+    parser = argparse.ArgumentParser()
+    parser.add_argument('-m', '--manifest', action='append', required=True,
+                        help='VINTF manifest fragment')
+    parser.add_argument('--aidl-interfaces', action='append', default=[],
+                        help='file listing stable AIDL interface names, one per line')
+    parser.add_argument('-s', '--service_contexts', action='append', default=[],
+                        help='service_contexts of all partitions')
+    parser.add_argument('-w', '--hwservice_contexts', action='append', default=[],
+                        help='hwservice_contexts of all partitions')
+    parser.add_argument('--stale-check', action='append', default=[],
+                        help='contexts file, also given with -s or -w, whose entries must be '
+                             'declared by a manifest')
+    args = parser.parse_args(argv)
+
+    errors = []
+    aidl = {}
+    hidl = {}
+    for path in args.manifest:
+        manifest_aidl, manifest_hidl, manifest_errors = parse_manifest(path)
+        for name, location in manifest_aidl.items():
+            aidl.setdefault(name, location)
+        for name, location in manifest_hidl.items():
+            hidl.setdefault(name, location)
+        errors.extend(manifest_errors)
+
+    aidl_interfaces = set()
+    for path in args.aidl_interfaces:
+        aidl_interfaces.update(read_aidl_interfaces(path))
+    aidl_interfaces.update(name.partition('/')[0] for name in aidl)
+
+    services = load_contexts(args.service_contexts, errors)
+    hwservices = load_contexts(args.hwservice_contexts, errors)
+    errors.extend(check_missing(aidl, hidl, [e for v in services.values() for e in v],
+                                [e for v in hwservices.values() for e in v]))
+
+    for path in args.stale_check:
+        if path in services:
+            errors.extend(check_stale_services(services[path], aidl, aidl_interfaces))
+        elif path in hwservices:
+            errors.extend(check_stale_hwservices(hwservices[path], hidl))
+        else:
+            sys.exit(f"Error: {path} must also be given with -s or -w")
+
+    if errors:
+        sys.exit('VINTF manifests don\'t match service_contexts and hwservice_contexts:\n' +
+                 '\n'.join(errors))
+
+
+if __name__ == '__main__':
+    do_main(sys.argv[1:])
@@ -0,0 +1,130 @@
+# Copyright 2024 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+"""Tests for vintf_contexts_check"""
+
+import os
+import shutil
+import tempfile
+import unittest
+
+import vintf_contexts_check as check
+
+
+MANIFEST = """<manifest version="1.0" type="device">
+    <hal format="aidl">
+        <name>android.hardware.foo</name>
+        <version>2</version>
+        <fqname>IFoo/default</fqname>
+        <fqname>IFoo/slot1</fqname>
+    </hal>
+    <hal format="aidl">
+        <name>vendor.acme.bar</name>
+        <interface>
+            <name>IBar</name>
+            <instance>default</instance>
+        </interface>
+    </hal>
+    <hal format="hidl">
+        <name>android.hardware.baz</name>
+        <transport>hwbinder</transport>
+        <fqname>@1.0::IBaz/default</fqname>
+    </hal>
+    <hal format="hidl">
+        <name>android.hardware.passthrough</name>
+        <transport>passthrough</transport>
+        <fqname>@1.0::IPassthrough/default</fqname>
+    </hal>
+    <hal format="native">
+        <name>mapper</name>
+    </hal>
+</manifest>
+"""
+
+SERVICE_CONTEXTS = """
+android.hardware.foo.IFoo/default     u:object_r:hal_foo_service:s0
+vendor.acme.bar.IBar/default          u:object_r:hal_bar_service:s0
+android.hardware.old.IOld/default     u:object_r:hal_old_service:s0
+vendor.acme.legacy/default            u:object_r:hal_legacy_service:s0
+activity                              u:object_r:activity_service:s0
+*                                     u:object_r:default_android_service:s0
+"""
+
+HWSERVICE_CONTEXTS = """
+android.hardware.baz::IBaz            u:object_r:hal_baz_hwservice:s0
+android.hardware.gone::IGone          u:object_r:hal_gone_hwservice:s0
+"""
+
+
+# pylint: disable=missing-docstring
+class VintfContextsCheckTest(unittest.TestCase):
+
+    def setUp(self):
+        self.temp_dir = tempfile.mkdtemp()
+        self.aidl, self.hidl, errors = check.parse_manifest(self.write('manifest.xml', MANIFEST))
+        self.assertEqual(errors, [])
+        self.services, _ = check.parse_contexts(self.write('service_contexts', SERVICE_CONTEXTS))
+        self.hwservices, _ = check.parse_contexts(
+            self.write('hwservice_contexts', HWSERVICE_CONTEXTS))
+
+    def tearDown(self):
+        shutil.rmtree(self.temp_dir)
+
+    def write(self, name, content):
+        path = os.path.join(self.temp_dir, name)
+        with open(path, 'w', encoding='utf-8') as f:
+            f.write(content)
+        return path
+
+    def test_parse_manifest(self):
+        self.assertEqual(sorted(self.aidl), [
+            'android.hardware.foo.IFoo/default',
+            'android.hardware.foo.IFoo/slot1',
+            'vendor.acme.bar.IBar/default',
+        ])
+        self.assertEqual(sorted(self.hidl), ['android.hardware.baz::IBaz'])
+
+    def test_missing(self):
+        errors = check.check_missing(self.aidl, self.hidl, self.services, self.hwservices)
+        self.assertEqual(len(errors), 1)
+        self.assertIn('android.hardware.foo.IFoo/slot1 has no service_contexts entry', errors[0])
+
+    def test_stale(self):
+        errors = check.check_stale_services(self.services, self.aidl, {'vendor.acme.legacy'})
+        self.assertEqual(len(errors), 2)
+        self.assertIn('android.hardware.old.IOld/default', errors[0])
+        self.assertIn('vendor.acme.legacy/default', errors[1])
+
+        errors = check.check_stale_hwservices(self.hwservices, self.hidl)
+        self.assertEqual(len(errors), 1)
+        self.assertIn('android.hardware.gone::IGone', errors[0])
+
+    def test_empty_elements(self):
+        _, _, errors = check.parse_manifest(self.write('empty.xml', """<manifest version="1.0">
+    <hal format="aidl">
+        <name>android.hardware.foo</name>
+        <interface>
+            <name>IFoo</name>
+            <instance/>
+        </interface>
+        <fqname/>
+    </hal>
+</manifest>
+"""))
+        self.assertEqual(len(errors), 2)
+        self.assertIn('android.hardware.foo: invalid interface IFoo/', errors[0])
+        self.assertIn('android.hardware.foo: invalid interface /', errors[1])
+
+
+if __name__ == '__main__':
+    unittest.main(verbosity=2)
@@ -22,6 +22,7 @@
 
 #define APP_DATA_REQUIRED_ATTRIB "app_data_file_type"
 #define COREDOMAIN "coredomain"
+#define MAX_DOMAIN_RULES 64
 
 /**
  * Initializes an empty, static list.
@@ -62,7 +63,7 @@ typedef struct list_element list_element;
 typedef struct list list;
 typedef struct key_map_regex key_map_regex;
 typedef struct file_info file_info;
-typedef struct coredomain_violation_entry coredomain_violation_entry;
+typedef struct domain_violation_entry domain_violation_entry;
 
 enum map_match {
 	map_no_matches,
@@ -151,7 +152,15 @@ struct policy_info {
 	sepol_policy_file_t *pf;
 	sepol_handle_t *handle;
 	sepol_context_t *con;
-	bool vendor;
+	/* Attributes which assigned domains must not have, e.g. coredomain for vendor */
+	const char *forbidden_attrs[MAX_DOMAIN_RULES];
+	size_t forbidden_attrs_cnt;
+	/* Attributes which assigned domains must all have */
+	const char *required_attrs[MAX_DOMAIN_RULES];
+	size_t required_attrs_cnt;
+	/* Domains which are exempt from the attribute rules */
+	const char *exempt_domains[MAX_DOMAIN_RULES];
+	size_t exempt_domains_cnt;
 };
 
 struct file_info {
@@ -160,14 +169,16 @@ struct file_info {
 	list_element listify;
 };
 
-struct coredomain_violation_entry {
+struct domain_violation_entry {
 	list_element listify;
 	char *domain;
+	const char *attribute; /** do not free, these are not alloc'd */
+	bool required; /** whether the attribute is required, rather than forbidden */
 	char *filename;
 	int lineno;
 };
 
-static void coredomain_violation_list_freefn(list_element *e);
+static void domain_violation_list_freefn(list_element *e);
 static void input_file_list_freefn(list_element *e);
 static void line_order_list_freefn(list_element *e);
 static void rule_map_free(rule_map *rm, bool is_in_htable);
@@ -180,7 +191,7 @@ static file_info out_file;
 
 static list input_file_list = list_init(input_file_list_freefn);
 
-static list coredomain_violation_list = list_init(coredomain_violation_list_freefn);
+static list domain_violation_list = list_init(domain_violation_list_freefn);
 
 static policy_info pol = {
 	.policy_file_name = NULL,
@@ -189,7 +200,9 @@ static policy_info pol = {
 	.pf = NULL,
 	.handle = NULL,
 	.con = NULL,
-	.vendor = false
+	.forbidden_attrs_cnt = 0,
+	.required_attrs_cnt = 0,
+	.exempt_domains_cnt = 0
 };
 
 /**
@@ -293,8 +306,8 @@ static void input_file_list_freefn(list_element *e) {
 	free(f);
 }
 
-static void coredomain_violation_list_freefn(list_element *e) {
-	coredomain_violation_entry *c = list_entry(e, typeof(*c), listify);
+static void domain_violation_list_freefn(list_element *e) {
+	domain_violation_entry *c = list_entry(e, typeof(*c), listify);
 
 	free(c->domain);
 	free(c->filename);
@@ -443,19 +456,32 @@ static bool validate_domain(char *value, const char *filename, int lineno, char
 		return false;
 	}
 
-	if (pol.vendor) {
-		type_datum_t *attrib_dat = find_type(pol.db, COREDOMAIN, TYPE_ATTRIB);
+	for (size_t i = 0; i < pol.exempt_domains_cnt; i++) {
+		if (!strcmp(value, pol.exempt_domains[i])) {
+			return true;
+		}
+	}
+
+	for (size_t i = 0; i < pol.forbidden_attrs_cnt + pol.required_attrs_cnt; i++) {
+		bool required = i >= pol.forbidden_attrs_cnt;
+		const char *attribute = required ? pol.required_attrs[i - pol.forbidden_attrs_cnt]
+						 : pol.forbidden_attrs[i];
+
+		type_datum_t *attrib_dat = find_type(pol.db, attribute, TYPE_ATTRIB);
 		if (!attrib_dat) {
-			*errmsg = "The attribute " COREDOMAIN " is not defined in the policy";
+			log_error("The attribute %s is not defined in the policy\n", attribute);
+			*errmsg = "An attribute of the domain rules is not defined in the policy";
 			return false;
 		}
 
-		if (type_has_attribute(pol.db, type_dat, attrib_dat)) {
-			coredomain_violation_entry *entry = (coredomain_violation_entry *)malloc(sizeof(*entry));
+		if (type_has_attribute(pol.db, type_dat, attrib_dat) != required) {
+			domain_violation_entry *entry = (domain_violation_entry *)malloc(sizeof(*entry));
 			entry->domain = strdup(value);
+			entry->attribute = attribute;
+			entry->required = required;
 			entry->filename = strdup(filename);
 			entry->lineno = lineno;
-			list_append(&coredomain_violation_list, &entry->listify);
+			list_append(&domain_violation_list, &entry->listify);
 		}
 	}
 #endif
@@ -934,6 +960,10 @@ static void usage() {
 		        "-h - print this help message\n"
 		        "-v - enable verbose debugging informations\n"
 		        "-p policy file - specify policy file for strict checking of output selectors against the policy\n"
+		        "-c - forbid domains with the " COREDOMAIN " attribute, same as -x " COREDOMAIN "\n"
+		        "-x attribute - forbid domains with the attribute. Can be repeated\n"
+		        "-r attribute - require domains to have the attribute. Can be repeated\n"
+		        "-e domain - exempt the domain from -c, -x and -r. Can be repeated\n"
 		        "-o output file - specify output file or - for stdout. No argument runs in silent mode and outputs nothing\n");
 }
 
@@ -1038,6 +1068,18 @@ static void init() {
 
 }
 
+/**
+ * Appends a value to one of the domain rule arrays of the policy_info. This
+ * function calls exit when the array is full.
+ */
+static void add_domain_rule(const char **values, size_t *cnt, const char *value) {
+	if (*cnt == MAX_DOMAIN_RULES) {
+		log_error("Too many domain rules, the maximum is %d per option\n", MAX_DOMAIN_RULES);
+		exit(EXIT_FAILURE);
+	}
+	values[(*cnt)++] = value;
+}
+
 /**
  * Handle parsing and setting the global flags for the command line
  * options. This function calls exit on failure.
@@ -1051,7 +1093,7 @@ static void handle_options(int argc, char *argv[]) {
 	int c;
 	file_info *input_file;
 
-	while ((c = getopt(argc, argv, "ho:p:vc")) != -1) {
+	while ((c = getopt(argc, argv, "ho:p:vcx:r:e:")) != -1) {
 		switch (c) {
 		case 'h':
 			usage();
@@ -1066,10 +1108,19 @@ static void handle_options(int argc, char *argv[]) {
 			log_set_verbose();
 			break;
 		case 'c':
-			pol.vendor = true;
+			add_domain_rule(pol.forbidden_attrs, &pol.forbidden_attrs_cnt, COREDOMAIN);
+			break;
+		case 'x':
+			add_domain_rule(pol.forbidden_attrs, &pol.forbidden_attrs_cnt, optarg);
+			break;
+		case 'r':
+			add_domain_rule(pol.required_attrs, &pol.required_attrs_cnt, optarg);
+			break;
+		case 'e':
+			add_domain_rule(pol.exempt_domains, &pol.exempt_domains_cnt, optarg);
 			break;
 		case '?':
-			if (optopt == 'o' || optopt == 'p')
+			if (optopt == 'o' || optopt == 'p' || optopt == 'x' || optopt == 'r' || optopt == 'e')
 				log_error("Option -%c requires an argument.\n", optopt);
 			else if (isprint (optopt))
 				log_error("Unknown option `-%c'.\n", optopt);
@@ -1286,7 +1337,7 @@ static void validate() {
 	bool found_issues = false;
 	hash_entry *e;
 	rule_map *r;
-	coredomain_violation_entry *c;
+	domain_violation_entry *c;
 	list_for_each(&line_order_list, cursor) {
 		e = list_entry(cursor, typeof(*e), listify);
 		rule_map_validate(e->r);
@@ -1307,11 +1358,17 @@ static void validate() {
 	}
 
 	bool coredomain_violation = false;
-	list_for_each(&coredomain_violation_list, cursor) {
+	list_for_each(&domain_violation_list, cursor) {
 		c = list_entry(cursor, typeof(*c), listify);
-		fprintf(stderr, "Forbidden attribute " COREDOMAIN " assigned to domain \"%s\" in "
-		        "File \"%s\" on line %d\n", c->domain, c->filename, c->lineno);
-		coredomain_violation = true;
+		if (c->required) {
+			fprintf(stderr, "Required attribute %s not assigned to domain \"%s\" in "
+			        "File \"%s\" on line %d\n", c->attribute, c->domain, c->filename, c->lineno);
+		} else {
+			fprintf(stderr, "Forbidden attribute %s assigned to domain \"%s\" in "
+			        "File \"%s\" on line %d\n", c->attribute, c->domain, c->filename, c->lineno);
+		}
+		coredomain_violation |= !c->required && !strcmp(c->attribute, COREDOMAIN);
+		found_issues = true;
 	}
 
 	if (coredomain_violation) {
@@ -1322,7 +1379,6 @@ static void validate() {
 		        "See an example of how to fix this:\n"
 		        "https://android-review.googlesource.com/2671075\n");
 		fprintf(stderr, "********************************************************************************\n");
-		found_issues = true;
 	}
 
 	if (found_issues) {
@@ -1383,7 +1439,7 @@ static void cleanup() {
 	list_free(&input_file_list);
 	list_free(&line_order_list);
 	list_free(&nallow_list);
-	list_free(&coredomain_violation_list);
+	list_free(&domain_violation_list);
 	hdestroy();
 }
 
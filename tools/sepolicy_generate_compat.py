@@ -16,6 +16,7 @@
 
 from pathlib import Path
 import argparse
+import compat_templates
 import glob
 import logging
 import mini_parser
@@ -29,21 +30,6 @@ import zipfile
 """This tool generates a mapping file for {ver} core sepolicy."""
 
 temp_dir = ''
-mapping_cil_footer = ";; mapping information from ToT policy's types to %s policy's types.\n"
-compat_cil_template = """;; complement CIL file for compatibility between ToT policy and %s vendors.
-;; will be compiled along with other normal policy files, on %s vendors.
-;;
-"""
-ignore_cil_template = """;; new_objects - a collection of types that have been introduced with ToT policy
-;;   that have no analogue in %s policy.  Thus, we do not need to map these types to
-;;   previous ones.  Add here to pass checkapi tests.
-(type new_objects)
-(typeattribute new_objects)
-(typeattributeset new_objects
-  ( new_objects
-    %s
-  ))
-"""
 
 SHARED_LIB_EXTENSION = '.dylib' if sys.platform == 'darwin' else '.so'
 
@@ -496,17 +482,16 @@ def main():
                 f.write(';; types removed from current policy\n')
                 f.write('\n'.join(f'(type {x})' for x in sorted(target_removed_types)))
                 f.write('\n\n')
-            f.write(mapping_cil_footer % args.target_version)
+            f.write(compat_templates.MAPPING_HEADER % args.target_version)
             f.write(mapping_file_cil.unparse())
 
         with open(target_compat_file, 'w') as f:
             logging.info('writing %s' % target_compat_file)
-            f.write(compat_cil_template % (args.target_version, args.target_version))
+            f.write(compat_templates.compat_cil(args.target_version))
 
         with open(target_ignore_file, 'w') as f:
             logging.info('writing %s' % target_ignore_file)
-            f.write(ignore_cil_template %
-                    (args.target_version, '\n    '.join(sorted(target_ignored_types))))
+            f.write(compat_templates.ignore_cil(args.target_version, target_ignored_types))
     finally:
         logging.info('Deleting temporary dir: {}'.format(temp_dir))
         shutil.rmtree(temp_dir)